@@ -0,0 +1,90 @@
+package lib
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"strconv"
+	"unicode/utf8"
+
+	"golang.org/x/net/html/charset"
+	"golang.org/x/text/encoding"
+)
+
+// ErrNotHTML is returned by ReadHTML for responses that aren't a web page.
+var ErrNotHTML = errors.New("not an html page")
+
+// MaxBodySize is how much of a page is read, configured through
+// CRAWL_MAX_BODY in bytes (default 10MB). Longer pages are cut off, which
+// still leaves their <head> and most of the article.
+func MaxBodySize() int64 {
+	if v, err := strconv.ParseInt(os.Getenv("CRAWL_MAX_BODY"), 10, 64); err == nil && v > 0 {
+		return v
+	}
+	return 10 << 20
+}
+
+// ReadHTML reads the body of an HTML response as UTF-8, up to MaxBodySize.
+// The charset is taken from a BOM, the Content-Type header or a <meta>
+// declaration, in that order. Responses declared, or sniffed, as something
+// other than HTML are refused with ErrNotHTML before the body is read, and
+// resp.Body is left readable from the start for other extractors.
+func ReadHTML(resp *http.Response) ([]byte, error) {
+	ct := resp.Header.Get("Content-Type")
+	br := bufio.NewReaderSize(resp.Body, 512)
+	resp.Body = struct {
+		io.Reader
+		io.Closer
+	}{br, resp.Body}
+
+	mt, _, _ := mime.ParseMediaType(ct)
+	switch mt {
+	case "text/html", "application/xhtml+xml":
+	case "", "application/octet-stream", "text/plain":
+		// often just a misconfigured server, look at the content itself
+		head, _ := br.Peek(512)
+		sniffed := http.DetectContentType(head)
+		if mt, _, _ = mime.ParseMediaType(sniffed); mt != "text/html" {
+			return nil, fmt.Errorf("%w: %s", ErrNotHTML, sniffed)
+		}
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrNotHTML, ct)
+	}
+
+	raw, err := io.ReadAll(io.LimitReader(br, MaxBodySize()))
+	if err != nil {
+		return nil, err
+	}
+
+	enc, _, certain := charset.DetermineEncoding(raw, ct)
+	// only the first 1024 bytes were looked at, and windows-1252 is a guess
+	if !certain && utf8.Valid(trimPartialRune(raw)) {
+		enc = encoding.Nop
+	}
+	if enc == encoding.Nop {
+		return raw, nil
+	}
+
+	return enc.NewDecoder().Bytes(raw)
+}
+
+// trimPartialRune drops a multi-byte sequence cut off at the end of b, as
+// happens when a page is longer than MaxBodySize.
+func trimPartialRune(b []byte) []byte {
+	for i := len(b) - 1; i >= 0 && i > len(b)-4; i-- {
+		if b[i] < utf8.RuneSelf {
+			break
+		}
+		if utf8.RuneStart(b[i]) {
+			if !utf8.FullRune(b[i:]) {
+				return b[:i]
+			}
+			break
+		}
+	}
+	return b
+}
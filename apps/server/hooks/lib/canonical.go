@@ -0,0 +1,195 @@
+package lib
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// defaultTrackingParams are stripped from every URL; a trailing "*" matches
+// on prefix. TRACKING_PARAMS adds a comma separated list to these.
+var defaultTrackingParams = []string{
+	"utm_*", "fbclid", "gclid", "dclid", "gbraid", "wbraid", "msclkid", "yclid",
+	"twclid", "ttclid", "li_fat_id", "igshid", "si", "mc_cid", "mc_eid",
+	"_hsenc", "_hsmi", "mkt_tok", "oly_anon_id", "oly_enc_id", "vero_id",
+	"_ga", "_gl", "ref_src", "ref_url", "spm",
+}
+
+// shorteners are unwrapped by Unshorten before crawling.
+var shorteners = map[string]bool{
+	"t.co": true, "bit.ly": true, "bitly.com": true, "goo.gl": true,
+	"tinyurl.com": true, "ow.ly": true, "buff.ly": true, "lnkd.in": true,
+	"is.gd": true, "rebrand.ly": true, "t.ly": true, "shorturl.at": true,
+	"cutt.ly": true, "tiny.cc": true, "rb.gy": true, "dlvr.it": true,
+	"fb.me": true, "trib.al": true, "amzn.to": true, "spoti.fi": true,
+	"wp.me": true, "s.id": true,
+}
+
+func isTrackingParam(name string) bool {
+	name = strings.ToLower(name)
+
+	params := defaultTrackingParams
+	if extra := os.Getenv("TRACKING_PARAMS"); extra != "" {
+		params = append(params[:len(params):len(params)], strings.Split(extra, ",")...)
+	}
+
+	for _, p := range params {
+		p = strings.ToLower(strings.TrimSpace(p))
+		if p == "" {
+			continue
+		}
+		if strings.HasSuffix(p, "*") {
+			if strings.HasPrefix(name, strings.TrimSuffix(p, "*")) {
+				return true
+			}
+		} else if name == p {
+			return true
+		}
+	}
+	return false
+}
+
+// Canonicalize normalizes u so equivalent links compare equal: it lowercases
+// the scheme and host, drops default ports and the fragment, strips tracking
+// parameters and sorts the remaining query. Scheme-less input gets https://.
+func Canonicalize(u string) (string, error) {
+	u = strings.TrimSpace(u)
+	if !strings.Contains(u, "://") {
+		u = "https://" + u
+	}
+
+	pu, err := url.Parse(u)
+	if err != nil {
+		return "", err
+	}
+	pu.Scheme = strings.ToLower(pu.Scheme)
+	if (pu.Scheme != "http" && pu.Scheme != "https") || pu.Hostname() == "" {
+		return "", fmt.Errorf("invalid url")
+	}
+
+	host := strings.TrimSuffix(strings.ToLower(pu.Hostname()), ".")
+	port := pu.Port()
+	if (pu.Scheme == "http" && port == "80") || (pu.Scheme == "https" && port == "443") {
+		port = ""
+	}
+	if strings.Contains(host, ":") {
+		host = "[" + host + "]"
+	}
+	if port != "" {
+		host += ":" + port
+	}
+	pu.Host = host
+
+	if pu.Path == "" {
+		pu.Path = "/"
+	}
+	pu.Fragment = ""
+	pu.RawFragment = ""
+	pu.User = nil
+
+	q := pu.Query()
+	for name := range q {
+		if isTrackingParam(name) {
+			q.Del(name)
+		}
+	}
+	pu.RawQuery = q.Encode()
+
+	return pu.String(), nil
+}
+
+// IsShortener reports whether u points at a known link shortener.
+func IsShortener(u string) bool {
+	pu, err := url.Parse(u)
+	if err != nil {
+		return false
+	}
+	return shorteners[strings.TrimPrefix(strings.ToLower(pu.Hostname()), "www.")]
+}
+
+// Unshorten follows a shortened link to its target. Redirects are followed by
+// the transport; shorteners that answer with an HTML page instead (t.co does,
+// and relays hide the redirect chain) are resolved from the page itself.
+func Unshorten(u string) (string, error) {
+	for hop := 0; hop < 5 && IsShortener(u); hop++ {
+		req, err := http.NewRequest("GET", u, nil)
+		if err != nil {
+			return "", err
+		}
+
+		// resolving a redirect isn't crawling, robots.txt doesn't apply
+		r, err := UseProxy(API(req))
+		if err != nil {
+			return "", err
+		}
+
+		var final *url.URL
+		if r.Request != nil {
+			final = r.Request.URL
+		}
+
+		next := ""
+		if final != nil && final.String() != u {
+			next = final.String()
+		} else if r.StatusCode < 400 {
+			next = targetFromPage(io.LimitReader(r.Body, 256<<10), final)
+		}
+		r.Body.Close()
+
+		if next == "" {
+			return "", fmt.Errorf("unshorten: no target for %s", u)
+		}
+		if u, err = Canonicalize(next); err != nil {
+			return "", err
+		}
+	}
+	return u, nil
+}
+
+func targetFromPage(body io.Reader, base *url.URL) string {
+	doc, err := goquery.NewDocumentFromReader(body)
+	if err != nil {
+		return ""
+	}
+
+	candidates := []string{}
+	doc.Find("meta[http-equiv]").Each(func(_ int, s *goquery.Selection) {
+		if v, _ := s.Attr("http-equiv"); !strings.EqualFold(v, "refresh") {
+			return
+		}
+		v, _ := s.Attr("content")
+		if i := strings.Index(strings.ToLower(v), "url="); i >= 0 {
+			candidates = append(candidates, strings.Trim(v[i+4:], `'" `))
+		}
+	})
+	if v, ok := doc.Find(`link[rel="canonical"]`).Attr("href"); ok {
+		candidates = append(candidates, v)
+	}
+	if v, ok := doc.Find(`meta[property="og:url"]`).Attr("content"); ok {
+		candidates = append(candidates, v)
+	}
+	if t := strings.TrimSpace(doc.Find("title").First().Text()); strings.HasPrefix(t, "http") {
+		candidates = append(candidates, t)
+	}
+
+	for _, c := range candidates {
+		ref, err := url.Parse(strings.TrimSpace(c))
+		if err != nil {
+			continue
+		}
+		if base != nil {
+			if ref = base.ResolveReference(ref); ref.String() == base.String() {
+				continue
+			}
+		}
+		if (ref.Scheme == "http" || ref.Scheme == "https") && ref.Host != "" {
+			return ref.String()
+		}
+	}
+	return ""
+}
@@ -1,40 +1,107 @@
 package lib
 
 import (
+	"bytes"
+	"errors"
+	"fmt"
 	"io"
 	"net/http"
-	"net/url"
-	"os"
 	"time"
 )
 
+// UseProxy fetches origReq through the configured transports, falling back to
+// the next one on a network error or a 5xx answer.
+//
+// Fetches are polite: robots.txt is honored unless the request context says
+// otherwise (see SkipRobots), every host is rate limited, a missing
+// User-Agent is filled in, and a short Retry-After on a 429 or 503 is waited
+// out and the request tried once more.
 func UseProxy(origReq *http.Request) (*http.Response, error) {
-	proxyBase := "https://proxy.bijsven.workers.dev"
-	originalURL := origReq.URL.String()
+	if err := CheckURL(origReq.URL); err != nil {
+		return nil, err
+	}
 
-	proxyURL := proxyBase + "?url=" + url.QueryEscape(originalURL)
+	if origReq.Header == nil {
+		origReq.Header = make(http.Header)
+	}
+	if origReq.Header.Get("User-Agent") == "" {
+		origReq.Header.Set("User-Agent", UserAgent(origReq.URL.Hostname()))
+	}
 
-	var body io.ReadCloser
-	if origReq.Body != nil {
-		body = origReq.Body
+	ctx := origReq.Context()
+	if !skipsRobots(ctx) && !robotsAllowed(origReq.URL) {
+		return nil, fmt.Errorf("%w: %s", ErrDisallowed, origReq.URL.String())
 	}
 
-	proxyReq, err := http.NewRequest(origReq.Method, proxyURL, body)
-	if err != nil {
-		return nil, err
+	var body []byte
+	if origReq.Body != nil {
+		b, err := io.ReadAll(origReq.Body)
+		origReq.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		body = b
 	}
 
-	if origReq.Header == nil {
-		origReq.Header = make(http.Header)
+	host := hostKey(origReq.URL)
+	for attempt := 0; ; attempt++ {
+		if err := waitHost(ctx, host); err != nil {
+			return nil, err
+		}
+
+		resp, err := fetch(origReq, body)
+		if err != nil {
+			return nil, err
+		}
+
+		wait := backoffHost(host, resp)
+		if wait > 0 && attempt == 0 && wait <= EnvDuration("FETCH_MAX_RETRY_AFTER", 30*time.Second) {
+			resp.Body.Close()
+			continue
+		}
+
+		record(origReq, body, resp)
+		return resp, nil
 	}
-	proxyReq.Header = origReq.Header.Clone()
+}
 
-	if proxyReq.Header == nil {
-		proxyReq.Header = make(http.Header)
+// fetch tries each transport in turn, returning the first answer below 500,
+// or the last 5xx one.
+func fetch(origReq *http.Request, body []byte) (*http.Response, error) {
+	var last *http.Response
+	var errs []error
+	for _, t := range transportsFor(origReq.Context()) {
+		req := origReq.Clone(origReq.Context())
+		if body != nil {
+			req.Body = io.NopCloser(bytes.NewReader(body))
+			req.ContentLength = int64(len(body))
+		}
+
+		resp, err := t.Do(req)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", t.Name(), err))
+			continue
+		}
+
+		if last != nil {
+			last.Body.Close()
+		}
+		if resp.StatusCode < 500 {
+			return resp, nil
+		}
+		last = resp
 	}
 
-	proxyReq.Header.Set("x-auth-token", os.Getenv("API_KEY"))
+	if last != nil {
+		return last, nil
+	}
+	return nil, errors.Join(errs...)
+}
 
-	client := &http.Client{Timeout: 10 * time.Second}
-	return client.Do(proxyReq)
+// record hands the exchange to the WARC recorder of the request context, if
+// there is one.
+func record(req *http.Request, body []byte, resp *http.Response) {
+	if rec := recorderFrom(req.Context()); rec != nil {
+		rec.capture(req, body, resp)
+	}
 }
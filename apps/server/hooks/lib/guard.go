@@ -0,0 +1,147 @@
+package lib
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"syscall"
+	"time"
+)
+
+var ErrBlockedAddress = errors.New("address not allowed")
+
+// lookupIPAddr resolves the hosts CheckURL checks.
+var lookupIPAddr = net.DefaultResolver.LookupIPAddr
+
+// SetResolver replaces the host lookup of CheckURL, e.g. to test crawlers
+// against recorded fixtures without DNS.
+func SetResolver(lookup func(ctx context.Context, host string) ([]net.IPAddr, error)) {
+	lookupIPAddr = lookup
+}
+
+// blockedNets holds the special-purpose ranges not covered by the net.IP
+// helpers used in IsBlockedIP.
+var blockedNets = func() []*net.IPNet {
+	var nets []*net.IPNet
+	for _, cidr := range []string{
+		"0.0.0.0/8",       // "this" network
+		"100.64.0.0/10",   // carrier-grade NAT
+		"192.0.0.0/24",    // IETF protocol assignments
+		"192.0.2.0/24",    // TEST-NET-1
+		"198.18.0.0/15",   // benchmarking
+		"198.51.100.0/24", // TEST-NET-2
+		"203.0.113.0/24",  // TEST-NET-3
+		"240.0.0.0/4",     // reserved
+		"64:ff9b::/96",    // NAT64, can map onto private IPv4
+		"2001:db8::/32",   // documentation
+	} {
+		_, n, _ := net.ParseCIDR(cidr)
+		nets = append(nets, n)
+	}
+	return nets
+}()
+
+// IsBlockedIP reports whether ip is a loopback, private, link-local or
+// otherwise non-public address the server must never fetch from.
+func IsBlockedIP(ip net.IP) bool {
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+	}
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() {
+		return true
+	}
+	for _, n := range blockedNets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// CheckURL rejects non-http(s) URLs and hosts resolving to a blocked address.
+// It is a pre-flight check only: connections made by SafeClient are verified
+// again on dial, after DNS resolution, so rebinding cannot slip through.
+func CheckURL(u *url.URL) error {
+	if u == nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return fmt.Errorf("%w: only http(s) allowed", ErrBlockedAddress)
+	}
+
+	host := u.Hostname()
+	if host == "" {
+		return fmt.Errorf("%w: missing host", ErrBlockedAddress)
+	}
+
+	if ip := net.ParseIP(host); ip != nil {
+		if IsBlockedIP(ip) {
+			return fmt.Errorf("%w: %s", ErrBlockedAddress, host)
+		}
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	addrs, err := lookupIPAddr(ctx, host)
+	if err != nil {
+		return err
+	}
+	for _, a := range addrs {
+		if IsBlockedIP(a.IP) {
+			return fmt.Errorf("%w: %s resolves to %s", ErrBlockedAddress, host, a.IP)
+		}
+	}
+	return nil
+}
+
+func guardControl(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || IsBlockedIP(ip) {
+		return fmt.Errorf("%w: %s", ErrBlockedAddress, host)
+	}
+	return nil
+}
+
+// safeTransport dials through a net.Dialer whose Control hook inspects the
+// resolved address of every connection. Environment proxies are ignored on
+// purpose, since the guard would only ever see the proxy's address.
+var safeTransport = &http.Transport{
+	DialContext: (&net.Dialer{
+		Timeout:   10 * time.Second,
+		KeepAlive: 30 * time.Second,
+		Control:   guardControl,
+	}).DialContext,
+	ForceAttemptHTTP2:     true,
+	MaxIdleConns:          100,
+	IdleConnTimeout:       90 * time.Second,
+	TLSHandshakeTimeout:   10 * time.Second,
+	ExpectContinueTimeout: 1 * time.Second,
+}
+
+// SafeClient returns an http.Client that refuses to connect to blocked
+// addresses and re-checks the target of every redirect.
+func SafeClient(timeout time.Duration) *http.Client {
+	return &http.Client{
+		Timeout:       timeout,
+		Transport:     safeTransport,
+		CheckRedirect: checkRedirect,
+	}
+}
+
+func checkRedirect(req *http.Request, via []*http.Request) error {
+	if v, _ := req.Context().Value(redirectsKey{}).(bool); v {
+		return http.ErrUseLastResponse
+	}
+	if len(via) >= 10 {
+		return errors.New("stopped after 10 redirects")
+	}
+	return CheckURL(req.URL)
+}
@@ -0,0 +1,112 @@
+package lib
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// LinkStatus is the outcome of checking a saved link.
+type LinkStatus struct {
+	// Status of the first response, before any redirect
+	Status int
+	// Final is where the redirects ended, empty without redirects
+	Final string
+	// Permanent is set when every redirect on the way was a 301 or 308
+	Permanent bool
+	// Broken is set when the link ends in a client or server error, or
+	// couldn't be reached at all
+	Broken bool
+}
+
+// CheckLink requests u with HEAD, or GET for servers refusing HEAD, and
+// follows up to 10 redirects by hand, so not through a relay. Rate
+// limits (429) and refusals (403), which most often mean bots are blocked,
+// are reported with Broken unset, since they say nothing about the link.
+func CheckLink(u string) (*LinkStatus, error) {
+	cur, err := url.Parse(u)
+	if err != nil {
+		return nil, err
+	}
+
+	fragment := cur.Fragment
+	st := &LinkStatus{Permanent: true}
+	redirected := false
+	for hop := 0; hop <= 10; hop++ {
+		if err := CheckURL(cur); err != nil {
+			st.Broken = true
+			return st, err
+		}
+
+		resp, err := checkHop("HEAD", cur)
+		if err == nil && (resp.StatusCode == 405 || resp.StatusCode == 501 || resp.StatusCode == 403 || resp.StatusCode == 404) {
+			resp, err = checkHop("GET", cur)
+		} else if err != nil {
+			resp, err = checkHop("GET", cur)
+		}
+		if err != nil {
+			st.Broken = true
+			return st, err
+		}
+
+		if hop == 0 {
+			st.Status = resp.StatusCode
+		}
+
+		loc := resp.Header.Get("Location")
+		if resp.StatusCode >= 300 && resp.StatusCode < 400 && loc != "" {
+			ref, err := url.Parse(loc)
+			if err != nil {
+				st.Broken = true
+				return st, err
+			}
+			if resp.StatusCode != 301 && resp.StatusCode != 308 {
+				st.Permanent = false
+			}
+			cur = cur.ResolveReference(ref)
+			redirected = true
+			continue
+		}
+
+		if redirected {
+			// a redirect without a fragment keeps the one of the link
+			if cur.Fragment == "" {
+				cur.Fragment = fragment
+			}
+			st.Final = cur.String()
+		} else {
+			st.Permanent = false
+		}
+		// sites blocking bots answer 403, and 429 only means slow down
+		st.Broken = resp.StatusCode >= 400 && resp.StatusCode != 403 && resp.StatusCode != 429
+		return st, nil
+	}
+
+	st.Broken = true
+	return st, fmt.Errorf("stopped after 10 redirects")
+}
+
+func checkHop(method string, u *url.URL) (*http.Response, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8")
+
+	// a check is no crawl, robots.txt doesn't apply; CheckLink sees every
+	// hop, which the relay would follow on its own
+	resp, err := UseProxy(noRedirects(API(req)))
+	if err != nil {
+		return nil, err
+	}
+	// only the status and headers matter
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	resp.Body.Close()
+	return resp, nil
+}
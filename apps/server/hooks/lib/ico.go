@@ -0,0 +1,151 @@
+package lib
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/color"
+	"image/png"
+)
+
+// ErrInvalidICO is returned for ICO files ICOToPNG can't read.
+var ErrInvalidICO = errors.New("invalid ico file")
+
+// ICOToPNG picks the largest image of an ICO file and returns it as a PNG,
+// together with its width. Entries are either PNGs, taken as they are, or
+// bitmaps with 1 to 32 bits per pixel.
+func ICOToPNG(b []byte) ([]byte, int, error) {
+	if len(b) < 6 || binary.LittleEndian.Uint16(b) != 0 || binary.LittleEndian.Uint16(b[2:]) != 1 {
+		return nil, 0, ErrInvalidICO
+	}
+
+	var best []byte
+	bestArea, bestBPP := 0, 0
+	for i, n := 0, int(binary.LittleEndian.Uint16(b[4:])); i < n; i++ {
+		e := 6 + i*16
+		if e+16 > len(b) {
+			break
+		}
+		w, h := int(b[e]), int(b[e+1])
+		if w == 0 {
+			w = 256
+		}
+		if h == 0 {
+			h = 256
+		}
+		bpp := int(binary.LittleEndian.Uint16(b[e+6:]))
+		size := int(binary.LittleEndian.Uint32(b[e+8:]))
+		off := int(binary.LittleEndian.Uint32(b[e+12:]))
+		if off < 0 || size <= 0 || off+size > len(b) || off+size < off {
+			continue
+		}
+		if w*h > bestArea || (w*h == bestArea && bpp > bestBPP) {
+			best, bestArea, bestBPP = b[off:off+size], w*h, bpp
+		}
+	}
+	if best == nil {
+		return nil, 0, ErrInvalidICO
+	}
+
+	if bytes.HasPrefix(best, []byte("\x89PNG\r\n\x1a\n")) {
+		cfg, err := png.DecodeConfig(bytes.NewReader(best))
+		if err != nil {
+			return nil, 0, err
+		}
+		return best, cfg.Width, nil
+	}
+
+	img, err := decodeDIB(best)
+	if err != nil {
+		return nil, 0, err
+	}
+	var out bytes.Buffer
+	if err := png.Encode(&out, img); err != nil {
+		return nil, 0, err
+	}
+	return out.Bytes(), img.Bounds().Dx(), nil
+}
+
+// decodeDIB decodes the headerless bitmap of an ICO entry: the pixels,
+// bottom-up, followed by a 1-bit transparency mask.
+func decodeDIB(b []byte) (image.Image, error) {
+	if len(b) < 40 {
+		return nil, ErrInvalidICO
+	}
+	hdr := int(binary.LittleEndian.Uint32(b))
+	w := int(int32(binary.LittleEndian.Uint32(b[4:])))
+	h := int(int32(binary.LittleEndian.Uint32(b[8:]))) / 2 // includes the mask
+	bpp := int(binary.LittleEndian.Uint16(b[14:]))
+	colors := int(binary.LittleEndian.Uint32(b[32:]))
+	if hdr < 40 || hdr > len(b) || w <= 0 || h <= 0 || w > 1024 || h > 1024 {
+		return nil, ErrInvalidICO
+	}
+
+	var palette []color.NRGBA
+	pos := hdr
+	if bpp <= 8 {
+		if colors == 0 {
+			colors = 1 << bpp
+		}
+		for i := 0; i < colors; i++ {
+			if pos+4 > len(b) {
+				return nil, ErrInvalidICO
+			}
+			palette = append(palette, color.NRGBA{R: b[pos+2], G: b[pos+1], B: b[pos], A: 0xFF})
+			pos += 4
+		}
+	}
+
+	switch bpp {
+	case 1, 4, 8, 24, 32:
+	default:
+		return nil, ErrInvalidICO
+	}
+
+	stride := (w*bpp + 31) / 32 * 4
+	maskStride := (w + 31) / 32 * 4
+	if pos+stride*h > len(b) {
+		return nil, ErrInvalidICO
+	}
+	mask := b[pos+stride*h:]
+
+	img := image.NewNRGBA(image.Rect(0, 0, w, h))
+	hasAlpha := false
+	for y := 0; y < h; y++ {
+		row := b[pos+(h-1-y)*stride:]
+		for x := 0; x < w; x++ {
+			var c color.NRGBA
+			switch bpp {
+			case 32:
+				c = color.NRGBA{R: row[x*4+2], G: row[x*4+1], B: row[x*4], A: row[x*4+3]}
+				hasAlpha = hasAlpha || c.A != 0
+			case 24:
+				c = color.NRGBA{R: row[x*3+2], G: row[x*3+1], B: row[x*3], A: 0xFF}
+			default:
+				bit := x * bpp
+				idx := int(row[bit/8]>>(8-bpp-bit%8)) & (1<<bpp - 1)
+				if idx < len(palette) {
+					c = palette[idx]
+				}
+			}
+			img.SetNRGBA(x, y, c)
+		}
+	}
+
+	// without an alpha channel, the mask says which pixels are transparent
+	if !hasAlpha && len(mask) >= maskStride*h {
+		for y := 0; y < h; y++ {
+			row := mask[(h-1-y)*maskStride:]
+			for x := 0; x < w; x++ {
+				c := img.NRGBAAt(x, y)
+				c.A = 0xFF
+				if row[x/8]>>(7-x%8)&1 == 1 {
+					c.A = 0
+				}
+				img.SetNRGBA(x, y, c)
+			}
+		}
+	}
+	return img, nil
+}
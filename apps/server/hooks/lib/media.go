@@ -0,0 +1,458 @@
+package lib
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"math"
+	"strings"
+)
+
+// MediaInfo is what ProbeMedia reads from the headers of an audio or video
+// container.
+type MediaInfo struct {
+	Container string
+	// Duration in seconds, zero when unknown
+	Duration float64
+	Codecs   []string
+	Width    int
+	Height   int
+	Video    bool
+}
+
+// ErrUnknownMedia is returned for containers ProbeMedia can't read.
+var ErrUnknownMedia = errors.New("unknown media container")
+
+// ProbeMedia reads the duration and codecs of an MP4/MOV, Matroska/WebM,
+// MP3, WAV, FLAC or Ogg file from head, its first bytes. size is the length
+// of the whole file, or 0 when unknown. When the header lives at the end of
+// the file (MP4 without faststart), tail is asked for the bytes from offset
+// on; it may be nil.
+func ProbeMedia(head []byte, size int64, tail func(offset int64) ([]byte, error)) (*MediaInfo, error) {
+	switch {
+	case len(head) >= 12 && string(head[4:8]) == "ftyp":
+		return probeMP4(head, tail)
+	case len(head) >= 4 && bytes.Equal(head[:4], []byte{0x1A, 0x45, 0xDF, 0xA3}):
+		return probeMatroska(head)
+	case len(head) >= 12 && string(head[:4]) == "RIFF" && string(head[8:12]) == "WAVE":
+		return probeWAV(head)
+	case len(head) >= 4 && string(head[:4]) == "fLaC":
+		return probeFLAC(head)
+	case len(head) >= 4 && string(head[:4]) == "OggS":
+		return probeOgg(head)
+	case len(head) >= 3 && (string(head[:3]) == "ID3" || (head[0] == 0xFF && head[1]&0xE0 == 0xE0)):
+		return probeMP3(head, size)
+	}
+	return nil, ErrUnknownMedia
+}
+
+// mp4 boxes
+
+type mp4Box struct {
+	typ  string
+	body []byte
+	size int64
+}
+
+// mp4Boxes splits b into boxes. The last box may be cut off, in which case
+// its body is what's there and size its real length.
+func mp4Boxes(b []byte) []mp4Box {
+	var boxes []mp4Box
+	for len(b) >= 8 {
+		size := int64(binary.BigEndian.Uint32(b))
+		typ := string(b[4:8])
+		hdr := int64(8)
+		switch size {
+		case 0:
+			size = int64(len(b))
+		case 1:
+			if len(b) < 16 {
+				return boxes
+			}
+			size = int64(binary.BigEndian.Uint64(b[8:]))
+			hdr = 16
+		}
+		if size < hdr {
+			return boxes
+		}
+		end := min(size, int64(len(b)))
+		boxes = append(boxes, mp4Box{typ: typ, body: b[hdr:end], size: size})
+		if size > int64(len(b)) {
+			return boxes
+		}
+		b = b[size:]
+	}
+	return boxes
+}
+
+func mp4Child(b []byte, typ string) []byte {
+	for _, box := range mp4Boxes(b) {
+		if box.typ == typ && int64(len(box.body)) == box.size-int64(8) {
+			return box.body
+		}
+	}
+	return nil
+}
+
+func probeMP4(head []byte, tail func(int64) ([]byte, error)) (*MediaInfo, error) {
+	info := &MediaInfo{Container: "mp4"}
+	if brand := string(head[8:12]); brand == "qt  " {
+		info.Container = "mov"
+	}
+
+	var moov []byte
+	offset := int64(0)
+	for _, box := range mp4Boxes(head) {
+		if box.typ == "moov" {
+			if int64(len(box.body))+8 >= box.size {
+				moov = box.body
+			}
+			break
+		}
+		offset += box.size
+	}
+
+	if moov == nil && tail != nil && offset > 0 {
+		if b, err := tail(offset); err == nil {
+			for _, box := range mp4Boxes(b) {
+				if box.typ == "moov" {
+					moov = box.body
+				}
+				break
+			}
+		}
+	}
+	if moov == nil {
+		return info, nil
+	}
+
+	if mvhd := mp4Child(moov, "mvhd"); len(mvhd) >= 20 {
+		var scale, dur uint64
+		if mvhd[0] == 1 && len(mvhd) >= 32 {
+			scale = uint64(binary.BigEndian.Uint32(mvhd[20:]))
+			dur = binary.BigEndian.Uint64(mvhd[24:])
+		} else {
+			scale = uint64(binary.BigEndian.Uint32(mvhd[12:]))
+			dur = uint64(binary.BigEndian.Uint32(mvhd[16:]))
+		}
+		if scale > 0 {
+			info.Duration = float64(dur) / float64(scale)
+		}
+	}
+
+	for _, trak := range mp4Boxes(moov) {
+		if trak.typ != "trak" {
+			continue
+		}
+		mdia := mp4Child(trak.body, "mdia")
+		hdlr := mp4Child(mdia, "hdlr")
+		if len(hdlr) < 12 {
+			continue
+		}
+		handler := string(hdlr[8:12])
+		if handler != "vide" && handler != "soun" {
+			continue
+		}
+
+		stsd := mp4Child(mp4Child(mp4Child(mdia, "minf"), "stbl"), "stsd")
+		if len(stsd) >= 16 {
+			info.Codecs = append(info.Codecs, strings.TrimSpace(string(stsd[12:16])))
+		}
+
+		if handler == "vide" {
+			info.Video = true
+			if tkhd := mp4Child(trak.body, "tkhd"); len(tkhd) >= 8 && info.Width == 0 {
+				info.Width = int(binary.BigEndian.Uint32(tkhd[len(tkhd)-8:]) >> 16)
+				info.Height = int(binary.BigEndian.Uint32(tkhd[len(tkhd)-4:]) >> 16)
+			}
+		}
+	}
+	return info, nil
+}
+
+// matroska / webm
+
+func ebmlVint(b []byte, keepMarker bool) (uint64, int) {
+	if len(b) == 0 || b[0] == 0 {
+		return 0, 0
+	}
+	n := 1
+	for mask := byte(0x80); b[0]&mask == 0; mask >>= 1 {
+		n++
+	}
+	if len(b) < n {
+		return 0, 0
+	}
+	v := uint64(b[0])
+	if !keepMarker {
+		v &= uint64(0xFF >> n)
+	}
+	for _, c := range b[1:n] {
+		v = v<<8 | uint64(c)
+	}
+	return v, n
+}
+
+type ebmlElement struct {
+	id   uint64
+	body []byte
+}
+
+// ebmlElements splits b into elements. Elements of unknown size, as used by
+// live streams, and elements cut off by the end of b get what's left of b.
+func ebmlElements(b []byte) []ebmlElement {
+	var els []ebmlElement
+	for len(b) > 0 {
+		id, n := ebmlVint(b, true)
+		if n == 0 {
+			return els
+		}
+		size, m := ebmlVint(b[n:], false)
+		if m == 0 {
+			return els
+		}
+		b = b[n+m:]
+		if size == (uint64(1)<<(7*m))-1 || size > uint64(len(b)) {
+			size = uint64(len(b))
+		}
+		els = append(els, ebmlElement{id: id, body: b[:size]})
+		b = b[size:]
+	}
+	return els
+}
+
+func ebmlUint(b []byte) uint64 {
+	var v uint64
+	for _, c := range b {
+		v = v<<8 | uint64(c)
+	}
+	return v
+}
+
+func probeMatroska(head []byte) (*MediaInfo, error) {
+	info := &MediaInfo{Container: "matroska"}
+	scale := uint64(1000000)
+
+	for _, el := range ebmlElements(head) {
+		switch el.id {
+		case 0x1A45DFA3: // EBML header
+			for _, h := range ebmlElements(el.body) {
+				if h.id == 0x4282 && string(h.body) == "webm" {
+					info.Container = "webm"
+				}
+			}
+		case 0x18538067: // Segment
+			for _, s := range ebmlElements(el.body) {
+				switch s.id {
+				case 0x1549A966: // Info
+					var dur float64
+					for _, i := range ebmlElements(s.body) {
+						switch i.id {
+						case 0x2AD7B1:
+							scale = ebmlUint(i.body)
+						case 0x4489:
+							if len(i.body) == 4 {
+								dur = float64(math.Float32frombits(binary.BigEndian.Uint32(i.body)))
+							} else if len(i.body) == 8 {
+								dur = math.Float64frombits(binary.BigEndian.Uint64(i.body))
+							}
+						}
+					}
+					info.Duration = dur * float64(scale) / 1e9
+				case 0x1654AE6B: // Tracks
+					for _, t := range ebmlElements(s.body) {
+						if t.id != 0xAE {
+							continue
+						}
+						for _, f := range ebmlElements(t.body) {
+							switch f.id {
+							case 0x86:
+								codec := strings.TrimPrefix(strings.TrimPrefix(string(f.body), "V_"), "A_")
+								info.Codecs = append(info.Codecs, strings.ToLower(strings.TrimRight(codec, "\x00")))
+							case 0xE0:
+								info.Video = true
+								for _, v := range ebmlElements(f.body) {
+									if v.id == 0xB0 && info.Width == 0 {
+										info.Width = int(ebmlUint(v.body))
+									}
+									if v.id == 0xBA && info.Height == 0 {
+										info.Height = int(ebmlUint(v.body))
+									}
+								}
+							}
+						}
+					}
+				case 0x1F43B675: // Cluster, the headers are done
+					return info, nil
+				}
+			}
+		}
+	}
+	return info, nil
+}
+
+// wav
+
+func probeWAV(head []byte) (*MediaInfo, error) {
+	info := &MediaInfo{Container: "wav"}
+	byteRate := uint32(0)
+
+	b := head[12:]
+	for len(b) >= 8 {
+		id := string(b[:4])
+		size := binary.LittleEndian.Uint32(b[4:])
+		body := b[8:]
+		switch id {
+		case "fmt ":
+			if len(body) >= 16 {
+				switch binary.LittleEndian.Uint16(body) {
+				case 1:
+					info.Codecs = append(info.Codecs, "pcm")
+				case 3:
+					info.Codecs = append(info.Codecs, "pcm_float")
+				case 85:
+					info.Codecs = append(info.Codecs, "mp3")
+				default:
+					info.Codecs = append(info.Codecs, "wav")
+				}
+				byteRate = binary.LittleEndian.Uint32(body[8:])
+			}
+		case "data":
+			if byteRate > 0 {
+				info.Duration = float64(size) / float64(byteRate)
+			}
+			return info, nil
+		}
+		next := 8 + int(size) + int(size&1)
+		if next > len(b) {
+			break
+		}
+		b = b[next:]
+	}
+	return info, nil
+}
+
+// flac
+
+func probeFLAC(head []byte) (*MediaInfo, error) {
+	info := &MediaInfo{Container: "flac", Codecs: []string{"flac"}}
+	// the first metadata block is always STREAMINFO
+	if len(head) < 8+18 || head[4]&0x7F != 0 {
+		return info, nil
+	}
+	si := head[8:]
+	rate := uint64(si[10])<<12 | uint64(si[11])<<4 | uint64(si[12])>>4
+	total := uint64(si[13]&0x0F)<<32 | uint64(binary.BigEndian.Uint32(si[14:]))
+	if rate > 0 {
+		info.Duration = float64(total) / float64(rate)
+	}
+	return info, nil
+}
+
+// ogg
+
+func probeOgg(head []byte) (*MediaInfo, error) {
+	info := &MediaInfo{Container: "ogg"}
+	if len(head) < 27 {
+		return info, nil
+	}
+	segs := int(head[26])
+	if len(head) < 27+segs {
+		return info, nil
+	}
+	packet := head[27+segs:]
+	switch {
+	case bytes.HasPrefix(packet, []byte("OpusHead")):
+		info.Codecs = []string{"opus"}
+	case bytes.HasPrefix(packet, []byte("\x01vorbis")):
+		info.Codecs = []string{"vorbis"}
+	case bytes.HasPrefix(packet, []byte("\x7fFLAC")):
+		info.Codecs = []string{"flac"}
+	case bytes.HasPrefix(packet, []byte("\x80theora")):
+		info.Codecs = []string{"theora"}
+		info.Video = true
+	}
+	return info, nil
+}
+
+// mp3
+
+var (
+	mp3Bitrates = [2][16]int{
+		{0, 32, 40, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320, 0}, // MPEG-1 layer III
+		{0, 8, 16, 24, 32, 40, 48, 56, 64, 80, 96, 112, 128, 144, 160, 0},     // MPEG-2/2.5 layer III
+	}
+	mp3Rates = [4][3]int{
+		{11025, 12000, 8000},  // MPEG-2.5
+		{},                    // reserved
+		{22050, 24000, 16000}, // MPEG-2
+		{44100, 48000, 32000}, // MPEG-1
+	}
+)
+
+func probeMP3(head []byte, size int64) (*MediaInfo, error) {
+	info := &MediaInfo{Container: "mp3", Codecs: []string{"mp3"}}
+
+	skip := 0
+	if len(head) >= 10 && string(head[:3]) == "ID3" {
+		skip = 10 + (int(head[6]&0x7F)<<21 | int(head[7]&0x7F)<<14 | int(head[8]&0x7F)<<7 | int(head[9]&0x7F))
+		if head[5]&0x10 != 0 {
+			skip += 10
+		}
+	}
+
+	// find the first frame header
+	for i := skip; i+4 <= len(head); i++ {
+		if head[i] != 0xFF || head[i+1]&0xE0 != 0xE0 {
+			continue
+		}
+		version := (head[i+1] >> 3) & 3
+		layer := (head[i+1] >> 1) & 3
+		bi := head[i+2] >> 4
+		ri := (head[i+2] >> 2) & 3
+		if version == 1 || layer != 1 || bi == 0 || bi == 15 || ri == 3 {
+			continue
+		}
+
+		table := 1
+		if version == 3 {
+			table = 0
+		}
+		bitrate := mp3Bitrates[table][bi] * 1000
+		rate := mp3Rates[version][ri]
+		mono := head[i+3]>>6 == 3
+
+		samples := 576
+		if version == 3 {
+			samples = 1152
+		}
+
+		// a Xing/Info or VBRI header counts the frames of VBR files
+		side := 17
+		switch {
+		case version == 3 && !mono:
+			side = 32
+		case version != 3 && mono:
+			side = 9
+		}
+		if x := i + 4 + side; x+12 <= len(head) {
+			tag := string(head[x : x+4])
+			if (tag == "Xing" || tag == "Info") && head[x+7]&1 != 0 {
+				frames := binary.BigEndian.Uint32(head[x+8:])
+				info.Duration = float64(frames) * float64(samples) / float64(rate)
+				return info, nil
+			}
+		}
+		if v := i + 4 + 32; v+18 <= len(head) && string(head[v:v+4]) == "VBRI" {
+			frames := binary.BigEndian.Uint32(head[v+14:])
+			info.Duration = float64(frames) * float64(samples) / float64(rate)
+			return info, nil
+		}
+
+		if size > int64(i) && bitrate > 0 {
+			info.Duration = float64(size-int64(i)) * 8 / float64(bitrate)
+		}
+		return info, nil
+	}
+	return info, nil
+}
@@ -0,0 +1,49 @@
+package lib
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"github.com/ledongthuc/pdf"
+)
+
+// PDFInfo is what ReadPDF gets out of a document.
+type PDFInfo struct {
+	Title     string
+	Author    string
+	Subject   string
+	Pages     int
+	FirstPage string
+}
+
+// ReadPDF reads the document information dictionary, the page count and the
+// text of the first page of a PDF. Encrypted documents only yield what's
+// readable without a password.
+func ReadPDF(b []byte) (info *PDFInfo, err error) {
+	// the parser panics on some malformed files
+	defer func() {
+		if rec := recover(); rec != nil {
+			info, err = nil, fmt.Errorf("pdf: %v", rec)
+		}
+	}()
+
+	r, err := pdf.NewReader(bytes.NewReader(b), int64(len(b)))
+	if err != nil {
+		return nil, err
+	}
+
+	info = &PDFInfo{Pages: r.NumPage()}
+
+	doc := r.Trailer().Key("Info")
+	info.Title = strings.TrimSpace(doc.Key("Title").Text())
+	info.Author = strings.TrimSpace(doc.Key("Author").Text())
+	info.Subject = strings.TrimSpace(doc.Key("Subject").Text())
+
+	if info.Pages > 0 {
+		if text, err := r.Page(1).GetPlainText(nil); err == nil {
+			info.FirstPage = strings.TrimSpace(text)
+		}
+	}
+	return info, nil
+}
@@ -0,0 +1,227 @@
+package lib
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// BrowserUserAgent is sent to the hosts in FETCH_BROWSER_UA_HOSTS, which
+// refuse or degrade pages for anything but a browser.
+const BrowserUserAgent = "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/137.0.0.0 Safari/537.36"
+
+const defaultUserAgent = "Mozilla/5.0 (compatible; dotpen/1.0; +https://dotpen.co)"
+
+// ErrHostBackoff is returned while a host asked us to come back later for
+// longer than FETCH_MAX_RETRY_AFTER.
+var ErrHostBackoff = errors.New("host asked to retry later")
+
+type politeKey struct{}
+
+// SkipRobots marks requests made with the returned context as API calls,
+// which robots.txt doesn't apply to. Rate limits still do.
+func SkipRobots(ctx context.Context) context.Context {
+	return context.WithValue(ctx, politeKey{}, true)
+}
+
+// API marks req as an API call, see SkipRobots.
+func API(req *http.Request) *http.Request {
+	return req.WithContext(SkipRobots(req.Context()))
+}
+
+// Asset marks req as fetching what a page embeds, such as an icon, image,
+// stylesheet or font. robots.txt only applies to the pages themselves.
+func Asset(req *http.Request) *http.Request {
+	return req.WithContext(SkipRobots(req.Context()))
+}
+
+// skipsRobots reports whether robots.txt is ignored for requests made with
+// ctx, either as an API call or because FETCH_RESPECT_ROBOTS is false.
+func skipsRobots(ctx context.Context) bool {
+	if v, err := strconv.ParseBool(os.Getenv("FETCH_RESPECT_ROBOTS")); err == nil && !v {
+		return true
+	}
+	v, _ := ctx.Value(politeKey{}).(bool)
+	return v
+}
+
+// UserAgent returns the User-Agent to send to host: FETCH_USER_AGENT, or a
+// browser's for the hosts (and their subdomains) in the comma separated
+// FETCH_BROWSER_UA_HOSTS.
+func UserAgent(host string) string {
+	host = strings.TrimPrefix(strings.ToLower(host), "www.")
+	for _, h := range strings.Split(os.Getenv("FETCH_BROWSER_UA_HOSTS"), ",") {
+		h = strings.TrimPrefix(strings.ToLower(strings.TrimSpace(h)), "www.")
+		if h != "" && (host == h || strings.HasSuffix(host, "."+h)) {
+			return BrowserUserAgent
+		}
+	}
+	if ua := os.Getenv("FETCH_USER_AGENT"); ua != "" {
+		return ua
+	}
+	return defaultUserAgent
+}
+
+func envFloat(key string, def float64) float64 {
+	if v, err := strconv.ParseFloat(os.Getenv(key), 64); err == nil && v > 0 {
+		return v
+	}
+	return def
+}
+
+// EnvDuration reads a duration like "90s" from the environment variable key,
+// or returns def when it is unset or invalid.
+func EnvDuration(key string, def time.Duration) time.Duration {
+	if d, err := time.ParseDuration(os.Getenv(key)); err == nil && d > 0 {
+		return d
+	}
+	return def
+}
+
+// bucket is a token bucket for one host. Tokens refill at rate per second up
+// to burst; until blocks the host entirely after a Retry-After.
+type bucket struct {
+	tokens float64
+	last   time.Time
+	until  time.Time
+	delay  time.Duration // robots.txt Crawl-delay
+}
+
+var (
+	bucketsMu    sync.Mutex
+	buckets      = map[string]*bucket{}
+	bucketsSwept time.Time
+)
+
+// hostIdle is how long the state of a host is kept after it was last
+// fetched, FETCH_HOST_IDLE (default 1h).
+func hostIdle() time.Duration {
+	return EnvDuration("FETCH_HOST_IDLE", time.Hour)
+}
+
+// sweepBuckets drops the buckets of hosts idle for hostIdle, at most once
+// per hostIdle. bucketsMu must be held.
+func sweepBuckets(now time.Time) {
+	idle := hostIdle()
+	if now.Sub(bucketsSwept) < idle {
+		return
+	}
+	bucketsSwept = now
+	for host, b := range buckets {
+		if now.Sub(b.last) > idle && now.After(b.until) {
+			delete(buckets, host)
+		}
+	}
+}
+
+func hostKey(u *url.URL) string {
+	return strings.TrimPrefix(strings.ToLower(u.Hostname()), "www.")
+}
+
+// waitHost blocks until host may be fetched again, at FETCH_HOST_RATE
+// requests per second (default 1) with bursts of FETCH_HOST_BURST (default
+// 3). A robots.txt Crawl-delay slows the rate down further.
+func waitHost(ctx context.Context, host string) error {
+	rate := envFloat("FETCH_HOST_RATE", 1)
+	burst := envFloat("FETCH_HOST_BURST", 3)
+	maxWait := EnvDuration("FETCH_MAX_RETRY_AFTER", 30*time.Second)
+
+	for {
+		bucketsMu.Lock()
+		now := time.Now()
+		sweepBuckets(now)
+		b := buckets[host]
+		if b == nil {
+			b = &bucket{tokens: burst, last: now}
+			buckets[host] = b
+		}
+
+		r := rate
+		if b.delay > 0 {
+			r = min(r, 1/b.delay.Seconds())
+			burst = 1
+		}
+		b.tokens = min(burst, b.tokens+now.Sub(b.last).Seconds()*r)
+		b.last = now
+
+		var wait time.Duration
+		switch {
+		case b.until.After(now):
+			wait = b.until.Sub(now)
+			if wait > maxWait {
+				bucketsMu.Unlock()
+				return fmt.Errorf("%w: %s until %s", ErrHostBackoff, host, b.until.Format(time.RFC3339))
+			}
+		case b.tokens >= 1:
+			b.tokens--
+			bucketsMu.Unlock()
+			return nil
+		default:
+			wait = time.Duration((1 - b.tokens) / r * float64(time.Second))
+		}
+		bucketsMu.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(wait):
+		}
+	}
+}
+
+// backoffHost records a Retry-After for host and returns how long it asked
+// to wait, or zero when the response didn't ask for anything.
+func backoffHost(host string, resp *http.Response) time.Duration {
+	if resp.StatusCode != http.StatusTooManyRequests && resp.StatusCode != http.StatusServiceUnavailable {
+		return 0
+	}
+
+	wait := parseRetryAfter(resp.Header.Get("Retry-After"))
+	if wait <= 0 {
+		return 0
+	}
+
+	bucketsMu.Lock()
+	defer bucketsMu.Unlock()
+	b := buckets[host]
+	if b == nil {
+		b = &bucket{last: time.Now()}
+		buckets[host] = b
+	}
+	if until := time.Now().Add(wait); until.After(b.until) {
+		b.until = until
+	}
+	return wait
+}
+
+func parseRetryAfter(v string) time.Duration {
+	v = strings.TrimSpace(v)
+	if v == "" {
+		return 0
+	}
+	if s, err := strconv.Atoi(v); err == nil {
+		return time.Duration(s) * time.Second
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		return time.Until(t)
+	}
+	return 0
+}
+
+func setCrawlDelay(host string, d time.Duration) {
+	bucketsMu.Lock()
+	defer bucketsMu.Unlock()
+	b := buckets[host]
+	if b == nil {
+		b = &bucket{last: time.Now(), tokens: 1}
+		buckets[host] = b
+	}
+	b.delay = min(d, 30*time.Second)
+}
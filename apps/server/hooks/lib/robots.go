@@ -0,0 +1,234 @@
+package lib
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrDisallowed is returned for URLs robots.txt doesn't allow us to fetch.
+var ErrDisallowed = errors.New("disallowed by robots.txt")
+
+// robotsAgent is the product token matched against User-agent lines.
+const robotsAgent = "dotpen"
+
+type robotsRule struct {
+	allow   bool
+	pattern string
+}
+
+type robots struct {
+	rules   []robotsRule
+	delay   time.Duration
+	fetched time.Time
+}
+
+var (
+	robotsMu    sync.Mutex
+	robotsCache = map[string]*robots{}
+	robotsFetch = map[string]*sync.Mutex{}
+	robotsSwept time.Time
+)
+
+// sweepRobots drops the expired robots.txt of origins, at most once per ttl.
+// robotsMu must be held.
+func sweepRobots(now time.Time, ttl time.Duration) {
+	if now.Sub(robotsSwept) < ttl {
+		return
+	}
+	robotsSwept = now
+	for origin, rb := range robotsCache {
+		if now.Sub(rb.fetched) <= ttl {
+			continue
+		}
+		// an origin being fetched keeps its lock
+		if lock := robotsFetch[origin]; lock == nil || lock.TryLock() {
+			delete(robotsCache, origin)
+			delete(robotsFetch, origin)
+			if lock != nil {
+				lock.Unlock()
+			}
+		}
+	}
+}
+
+// robotsAllowed reports whether the robots.txt of u's origin lets us fetch
+// it. The file is cached per origin for FETCH_ROBOTS_TTL (default 24h); a
+// missing or unreachable robots.txt allows everything.
+func robotsAllowed(u *url.URL) bool {
+	origin := u.Scheme + "://" + u.Host
+	ttl := EnvDuration("FETCH_ROBOTS_TTL", 24*time.Hour)
+
+	robotsMu.Lock()
+	sweepRobots(time.Now(), ttl)
+	lock := robotsFetch[origin]
+	if lock == nil {
+		lock = &sync.Mutex{}
+		robotsFetch[origin] = lock
+	}
+	robotsMu.Unlock()
+
+	// one fetch per origin, concurrent requests wait for it
+	lock.Lock()
+	robotsMu.Lock()
+	rb := robotsCache[origin]
+	robotsMu.Unlock()
+	if rb == nil || time.Since(rb.fetched) > ttl {
+		rb = fetchRobots(origin)
+		robotsMu.Lock()
+		robotsCache[origin] = rb
+		robotsMu.Unlock()
+	}
+	lock.Unlock()
+
+	// set every time, the host's bucket may have been dropped meanwhile
+	if rb.delay > 0 {
+		setCrawlDelay(hostKey(u), rb.delay)
+	}
+
+	path := u.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	if u.RawQuery != "" {
+		path += "?" + u.RawQuery
+	}
+	return rb.allowed(path)
+}
+
+func fetchRobots(origin string) *robots {
+	rb := &robots{fetched: time.Now()}
+
+	req, err := http.NewRequestWithContext(SkipRobots(context.Background()), "GET", origin+"/robots.txt", nil)
+	if err != nil {
+		return rb
+	}
+	r, err := UseProxy(req)
+	if err != nil {
+		return rb
+	}
+	defer r.Body.Close()
+
+	if r.StatusCode != 200 {
+		return rb
+	}
+	return parseRobots(io.LimitReader(r.Body, 512<<10), rb)
+}
+
+// parseRobots reads the group for our agent, or the "*" group when there is
+// none, as described in RFC 9309.
+func parseRobots(body io.Reader, rb *robots) *robots {
+	type group struct {
+		agents []string
+		rules  []robotsRule
+		delay  time.Duration
+	}
+
+	var groups []*group
+	var cur *group
+	inAgents := false
+
+	sc := bufio.NewScanner(body)
+	for sc.Scan() {
+		line := sc.Text()
+		if i := strings.Index(line, "#"); i >= 0 {
+			line = line[:i]
+		}
+		key, val, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		key = strings.ToLower(strings.TrimSpace(key))
+		val = strings.TrimSpace(val)
+
+		switch key {
+		case "user-agent":
+			if !inAgents {
+				cur = &group{}
+				groups = append(groups, cur)
+			}
+			cur.agents = append(cur.agents, strings.ToLower(val))
+			inAgents = true
+		case "allow", "disallow":
+			inAgents = false
+			if cur == nil || (key == "disallow" && val == "") {
+				continue
+			}
+			cur.rules = append(cur.rules, robotsRule{allow: key == "allow", pattern: val})
+		case "crawl-delay":
+			inAgents = false
+			if cur == nil {
+				continue
+			}
+			if s, err := strconv.ParseFloat(val, 64); err == nil && s > 0 {
+				cur.delay = time.Duration(s * float64(time.Second))
+			}
+		default:
+			inAgents = false
+		}
+	}
+
+	var match, star []*group
+	for _, g := range groups {
+		for _, a := range g.agents {
+			if a == "*" {
+				star = append(star, g)
+			} else if a == robotsAgent || strings.HasPrefix(a, robotsAgent+"/") {
+				match = append(match, g)
+			}
+		}
+	}
+	if len(match) == 0 {
+		match = star
+	}
+	for _, g := range match {
+		rb.rules = append(rb.rules, g.rules...)
+		rb.delay = max(rb.delay, g.delay)
+	}
+	return rb
+}
+
+// allowed applies the longest matching rule; on a tie, allow wins.
+func (rb *robots) allowed(path string) bool {
+	best, allow := -1, true
+	for _, r := range rb.rules {
+		if !robotsMatch(r.pattern, path) {
+			continue
+		}
+		if n := len(r.pattern); n > best || (n == best && r.allow) {
+			best, allow = n, r.allow
+		}
+	}
+	return allow
+}
+
+// robotsMatch matches path against a robots.txt pattern, where "*" matches
+// any run of characters and a trailing "$" anchors the end.
+func robotsMatch(pattern, path string) bool {
+	anchored := strings.HasSuffix(pattern, "$")
+	pattern = strings.TrimSuffix(pattern, "$")
+
+	parts := strings.Split(pattern, "*")
+	if !strings.HasPrefix(path, parts[0]) {
+		return false
+	}
+	pos := len(parts[0])
+	for i, p := range parts[1:] {
+		if i == len(parts)-2 && anchored {
+			return len(path)-len(p) >= pos && strings.HasSuffix(path, p)
+		}
+		j := strings.Index(path[pos:], p)
+		if j < 0 {
+			return false
+		}
+		pos += j + len(p)
+	}
+	return !anchored || pos == len(path)
+}
@@ -0,0 +1,228 @@
+package lib
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Transport performs outbound fetches on behalf of the crawlers. UseProxy
+// tries the configured transports in order until one of them answers.
+type Transport interface {
+	Name() string
+	Do(req *http.Request) (*http.Response, error)
+}
+
+// DirectTransport fetches from the server itself, through SafeClient.
+type DirectTransport struct {
+	Client *http.Client
+}
+
+func NewDirectTransport(timeout time.Duration) *DirectTransport {
+	return &DirectTransport{Client: SafeClient(timeout)}
+}
+
+func (t *DirectTransport) Name() string { return "direct" }
+
+func (t *DirectTransport) Do(req *http.Request) (*http.Response, error) {
+	return t.Client.Do(req)
+}
+
+// RelayTransport hands the request to a worker-style relay that fetches
+// Base + "?url=<target>", authenticated with the x-auth-token header.
+type RelayTransport struct {
+	Base   string
+	Key    string
+	Client *http.Client
+}
+
+func NewRelayTransport(base, key string, timeout time.Duration) *RelayTransport {
+	return &RelayTransport{Base: base, Key: key, Client: &http.Client{Timeout: timeout}}
+}
+
+func (t *RelayTransport) Name() string { return "relay" }
+
+func (t *RelayTransport) Do(req *http.Request) (*http.Response, error) {
+	sep := "?"
+	if strings.Contains(t.Base, "?") {
+		sep = "&"
+	}
+	relayURL := t.Base + sep + "url=" + url.QueryEscape(req.URL.String())
+
+	relayReq, err := http.NewRequestWithContext(req.Context(), req.Method, relayURL, req.Body)
+	if err != nil {
+		return nil, err
+	}
+	relayReq.Header = req.Header.Clone()
+	if relayReq.Header == nil {
+		relayReq.Header = make(http.Header)
+	}
+	if t.Key != "" {
+		relayReq.Header.Set("x-auth-token", t.Key)
+	}
+
+	resp, err := t.Client.Do(relayReq)
+	if err != nil {
+		return nil, err
+	}
+	// Report the target rather than the relay, as the other transports do.
+	resp.Request = req
+	return resp, nil
+}
+
+// ProxyTransport fetches through a standard HTTP(S) or SOCKS5 proxy.
+type ProxyTransport struct {
+	Proxy  *url.URL
+	Client *http.Client
+}
+
+func NewProxyTransport(proxy *url.URL, timeout time.Duration) *ProxyTransport {
+	return &ProxyTransport{
+		Proxy: proxy,
+		Client: &http.Client{
+			Timeout:   timeout,
+			Transport: &http.Transport{Proxy: http.ProxyURL(proxy), ForceAttemptHTTP2: true},
+			// The proxy resolves the hosts, so only the pre-flight check applies.
+			CheckRedirect: checkRedirect,
+		},
+	}
+}
+
+func (t *ProxyTransport) Name() string { return "proxy" }
+
+func (t *ProxyTransport) Do(req *http.Request) (*http.Response, error) {
+	return t.Client.Do(req)
+}
+
+var (
+	transports     []Transport
+	transportsOnce sync.Once
+	transportsMu   sync.RWMutex
+)
+
+// SetTransports replaces the configured transports, e.g. to point the
+// crawlers at a local stand-in.
+func SetTransports(ts ...Transport) {
+	transportsOnce.Do(func() {})
+
+	transportsMu.Lock()
+	defer transportsMu.Unlock()
+	transports = ts
+}
+
+// Transports returns the transports in fallback order, configured through:
+//
+//	FETCH_TRANSPORTS      comma separated order of "direct", "relay" and "proxy";
+//	                      defaults to "relay" when API_KEY is set, else "direct"
+//	FETCH_RELAY_URL       relay base URL (default https://proxy.bijsven.workers.dev)
+//	FETCH_RELAY_KEY       relay token (default API_KEY)
+//	FETCH_PROXY_URL       http://, https:// or socks5:// proxy for "proxy"
+//	FETCH_TIMEOUT         default timeout per transport (default 10s)
+//	FETCH_<NAME>_TIMEOUT  timeout for one transport, e.g. FETCH_RELAY_TIMEOUT=20s
+func Transports() []Transport {
+	transportsOnce.Do(func() {
+		transports = loadTransports()
+	})
+
+	transportsMu.RLock()
+	defer transportsMu.RUnlock()
+	return transports
+}
+
+// transportTimeout is FETCH_<NAME>_TIMEOUT, FETCH_TIMEOUT or 10s.
+func transportTimeout(name string) time.Duration {
+	for _, key := range []string{"FETCH_" + strings.ToUpper(name) + "_TIMEOUT", "FETCH_TIMEOUT"} {
+		if d, err := time.ParseDuration(os.Getenv(key)); err == nil && d > 0 {
+			return d
+		}
+	}
+	return 10 * time.Second
+}
+
+func loadTransports() []Transport {
+	order := os.Getenv("FETCH_TRANSPORTS")
+	if order == "" {
+		order = "direct"
+		if os.Getenv("API_KEY") != "" {
+			order = "relay"
+		}
+	}
+
+	var ts []Transport
+	for _, name := range strings.Split(order, ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+
+		switch name {
+		case "direct":
+			ts = append(ts, NewDirectTransport(transportTimeout(name)))
+		case "relay":
+			base := os.Getenv("FETCH_RELAY_URL")
+			if base == "" {
+				base = "https://proxy.bijsven.workers.dev"
+			}
+			key := os.Getenv("FETCH_RELAY_KEY")
+			if key == "" {
+				key = os.Getenv("API_KEY")
+			}
+			ts = append(ts, NewRelayTransport(base, key, transportTimeout(name)))
+		case "proxy":
+			if pu, err := url.Parse(os.Getenv("FETCH_PROXY_URL")); err == nil && pu.Host != "" {
+				ts = append(ts, NewProxyTransport(pu, transportTimeout(name)))
+			}
+		}
+	}
+
+	if len(ts) == 0 {
+		ts = append(ts, NewDirectTransport(transportTimeout("direct")))
+	}
+	return ts
+}
+
+type privateKey struct{}
+
+// Private marks req as carrying credentials of this server, such as an API
+// token. It is only fetched from the server itself, never handed to a relay
+// or proxy that would see them.
+func Private(req *http.Request) *http.Request {
+	return req.WithContext(context.WithValue(req.Context(), privateKey{}, true))
+}
+
+// transportsFor returns the transports a request made with ctx may use.
+func transportsFor(ctx context.Context) []Transport {
+	ts := Transports()
+	private, _ := ctx.Value(privateKey{}).(bool)
+	manual, _ := ctx.Value(redirectsKey{}).(bool)
+	if !private && !manual {
+		return ts
+	}
+
+	var direct []Transport
+	for _, t := range ts {
+		switch t.(type) {
+		case *RelayTransport:
+		case *ProxyTransport:
+			if !private {
+				direct = append(direct, t)
+			}
+		default:
+			direct = append(direct, t)
+		}
+	}
+	if len(direct) == 0 {
+		direct = append(direct, NewDirectTransport(transportTimeout("direct")))
+	}
+	return direct
+}
+
+type redirectsKey struct{}
+
+// noRedirects makes the transports hand back redirects rather than follow
+// them. A relay follows them on its own and would only show the final
+// answer, so such requests skip it and go direct or through a proxy.
+func noRedirects(req *http.Request) *http.Request {
+	return req.WithContext(context.WithValue(req.Context(), redirectsKey{}, true))
+}
@@ -0,0 +1,254 @@
+package lib
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base32"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxWARCPayload caps the body kept for one response; longer bodies are
+// cut and marked with WARC-Truncated.
+const maxWARCPayload = 50 << 20
+
+type recorderKey struct{}
+
+// WARCRecorder collects the HTTP exchanges made through UseProxy with a
+// context carrying it, and writes them out as WARC 1.1 request/response
+// record pairs. Bodies are stored as received by the client, i.e. already
+// de-chunked and decompressed, and the headers are adjusted to match.
+type WARCRecorder struct {
+	mu        sync.Mutex
+	exchanges []*warcExchange
+}
+
+type warcExchange struct {
+	date      time.Time
+	req       *http.Request
+	reqBody   []byte
+	resp      *http.Response
+	body      bytes.Buffer
+	truncated bool
+}
+
+func NewWARCRecorder() *WARCRecorder {
+	return &WARCRecorder{}
+}
+
+// WithRecorder returns a context whose requests are recorded into rec.
+func WithRecorder(ctx context.Context, rec *WARCRecorder) context.Context {
+	return context.WithValue(ctx, recorderKey{}, rec)
+}
+
+func recorderFrom(ctx context.Context) *WARCRecorder {
+	rec, _ := ctx.Value(recorderKey{}).(*WARCRecorder)
+	return rec
+}
+
+// Len returns the number of exchanges recorded so far.
+func (r *WARCRecorder) Len() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.exchanges)
+}
+
+// capture wraps the body of resp so it is recorded while the caller reads
+// it. The exchange is kept once the body is closed.
+func (r *WARCRecorder) capture(req *http.Request, reqBody []byte, resp *http.Response) {
+	if resp.Request != nil {
+		req = resp.Request
+	}
+	ex := &warcExchange{date: time.Now().UTC(), req: req, reqBody: reqBody, resp: resp}
+	resp.Body = &captureBody{rc: resp.Body, ex: ex, rec: r}
+}
+
+type captureBody struct {
+	rc     io.ReadCloser
+	ex     *warcExchange
+	rec    *WARCRecorder
+	eof    bool
+	closed bool
+}
+
+func (c *captureBody) Read(p []byte) (int, error) {
+	n, err := c.rc.Read(p)
+	if n > 0 {
+		if room := maxWARCPayload - c.ex.body.Len(); room > 0 {
+			c.ex.body.Write(p[:min(n, room)])
+		}
+		if c.ex.body.Len() >= maxWARCPayload {
+			c.ex.truncated = true
+		}
+	}
+	if err == io.EOF {
+		c.eof = true
+	}
+	return n, err
+}
+
+func (c *captureBody) Close() error {
+	if !c.closed {
+		c.closed = true
+		if !c.eof {
+			c.ex.truncated = true
+		}
+		c.rec.mu.Lock()
+		c.rec.exchanges = append(c.rec.exchanges, c.ex)
+		c.rec.mu.Unlock()
+	}
+	return c.rc.Close()
+}
+
+// WriteTo writes a warcinfo record followed by the recorded exchanges to w,
+// each record as its own gzip member, as expected of a .warc.gz file.
+func (r *WARCRecorder) WriteTo(w io.Writer) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	cw := &countWriter{w: w}
+	if err := WriteWARCInfo(cw, ""); err != nil {
+		return cw.n, err
+	}
+
+	for _, ex := range r.exchanges {
+		reqID := warcRecordID()
+		target := ex.req.URL.String()
+
+		resp := warcResponseBlock(ex)
+		headers := []string{
+			"WARC-Target-URI", target,
+			"WARC-Payload-Digest", warcDigest(ex.body.Bytes()),
+			"WARC-Concurrent-To", reqID,
+		}
+		if ex.truncated {
+			headers = append(headers, "WARC-Truncated", "length")
+		}
+		if err := writeWARCRecord(cw, "response", warcRecordID(), ex.date, "application/http;msgtype=response", resp, headers...); err != nil {
+			return cw.n, err
+		}
+
+		req := warcRequestBlock(ex)
+		if err := writeWARCRecord(cw, "request", reqID, ex.date, "application/http;msgtype=request", req,
+			"WARC-Target-URI", target,
+		); err != nil {
+			return cw.n, err
+		}
+	}
+	return cw.n, nil
+}
+
+// WriteWARCInfo writes a warcinfo record describing the software and format,
+// as the first record of a WARC file.
+func WriteWARCInfo(w io.Writer, filename string) error {
+	info := "software: dotpen\r\nformat: WARC File Format 1.1\r\nconformsTo: http://iipc.github.io/warc-specifications/specifications/warc-format/warc-1.1/\r\n"
+	headers := []string{}
+	if filename != "" {
+		headers = append(headers, "WARC-Filename", filename)
+	}
+	return writeWARCRecord(w, "warcinfo", warcRecordID(), time.Now().UTC(), "application/warc-fields", []byte(info), headers...)
+}
+
+func writeWARCRecord(w io.Writer, typ, id string, date time.Time, contentType string, block []byte, headers ...string) error {
+	var b bytes.Buffer
+	b.WriteString("WARC/1.1\r\n")
+	fmt.Fprintf(&b, "WARC-Type: %s\r\n", typ)
+	fmt.Fprintf(&b, "WARC-Record-ID: %s\r\n", id)
+	fmt.Fprintf(&b, "WARC-Date: %s\r\n", date.Format("2006-01-02T15:04:05.000000Z"))
+	for i := 0; i+1 < len(headers); i += 2 {
+		fmt.Fprintf(&b, "%s: %s\r\n", headers[i], headers[i+1])
+	}
+	fmt.Fprintf(&b, "WARC-Block-Digest: %s\r\n", warcDigest(block))
+	fmt.Fprintf(&b, "Content-Type: %s\r\n", contentType)
+	fmt.Fprintf(&b, "Content-Length: %d\r\n", len(block))
+	b.WriteString("\r\n")
+	b.Write(block)
+	b.WriteString("\r\n\r\n")
+
+	gz := gzip.NewWriter(w)
+	if _, err := gz.Write(b.Bytes()); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+func warcRequestBlock(ex *warcExchange) []byte {
+	var b bytes.Buffer
+	method := ex.req.Method
+	if method == "" {
+		method = "GET"
+	}
+	fmt.Fprintf(&b, "%s %s HTTP/1.1\r\n", method, ex.req.URL.RequestURI())
+	fmt.Fprintf(&b, "Host: %s\r\n", ex.req.URL.Host)
+	writeHeaders(&b, ex.req.Header, nil)
+	b.WriteString("\r\n")
+	b.Write(ex.reqBody)
+	return b.Bytes()
+}
+
+func warcResponseBlock(ex *warcExchange) []byte {
+	var b bytes.Buffer
+	status := ex.resp.Status
+	if status == "" || !strings.HasPrefix(status, strconv.Itoa(ex.resp.StatusCode)) {
+		status = fmt.Sprintf("%d %s", ex.resp.StatusCode, http.StatusText(ex.resp.StatusCode))
+	}
+	fmt.Fprintf(&b, "HTTP/1.1 %s\r\n", status)
+
+	skip := map[string]bool{"Transfer-Encoding": true, "Content-Length": true}
+	if ex.resp.Uncompressed {
+		skip["Content-Encoding"] = true
+	}
+	writeHeaders(&b, ex.resp.Header, skip)
+	fmt.Fprintf(&b, "Content-Length: %d\r\n", ex.body.Len())
+	b.WriteString("\r\n")
+	b.Write(ex.body.Bytes())
+	return b.Bytes()
+}
+
+func writeHeaders(w io.Writer, h http.Header, skip map[string]bool) {
+	keys := make([]string, 0, len(h))
+	for k := range h {
+		if !skip[http.CanonicalHeaderKey(k)] {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		for _, v := range h[k] {
+			fmt.Fprintf(w, "%s: %s\r\n", k, v)
+		}
+	}
+}
+
+func warcDigest(b []byte) string {
+	sum := sha1.Sum(b)
+	return "sha1:" + base32.StdEncoding.EncodeToString(sum[:])
+}
+
+func warcRecordID() string {
+	var u [16]byte
+	_, _ = rand.Read(u[:])
+	u[6] = (u[6] & 0x0f) | 0x40
+	u[8] = (u[8] & 0x3f) | 0x80
+	return fmt.Sprintf("<urn:uuid:%x-%x-%x-%x-%x>", u[0:4], u[4:6], u[6:8], u[8:10], u[10:])
+}
+
+type countWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
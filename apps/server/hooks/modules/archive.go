@@ -0,0 +1,405 @@
+package modules
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"dotpen.co/server/hooks/lib"
+	"github.com/PuerkitoBio/goquery"
+	"github.com/gabriel-vasile/mimetype"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tools/filesystem"
+	"golang.org/x/net/html"
+)
+
+// archiveCSP keeps an archived page from loading or running anything: all it
+// may use is what was inlined into it.
+const archiveCSP = "default-src 'none'; img-src data:; style-src 'unsafe-inline' data:; font-src data:; media-src data:; sandbox"
+
+var (
+	rxCSSURL    = regexp.MustCompile(`url\(\s*(?:"([^"]*)"|'([^']*)'|([^)'"]*))\s*\)`)
+	rxCSSImport = regexp.MustCompile(`@import\s+(?:url\(\s*)?["']?([^"')\s;]+)["']?\s*\)?([^;]*);`)
+)
+
+// ArchiveEnabled reports whether new bookmarks are archived, configured
+// through ARCHIVE_PAGES (default true).
+func ArchiveEnabled() bool {
+	v, err := strconv.ParseBool(os.Getenv("ARCHIVE_PAGES"))
+	return err != nil || v
+}
+
+// archiveMaxSize caps a whole archive, configured through ARCHIVE_MAX_SIZE in
+// bytes (default 20MB). Resources past it are linked instead of inlined.
+func archiveMaxSize() int {
+	return envInt("ARCHIVE_MAX_SIZE", 20<<20)
+}
+
+type archiver struct {
+	ctx    context.Context
+	budget int
+	cache  map[string]string
+}
+
+// Archive fetches u and turns it into a single self-contained HTML file:
+// stylesheets, images and fonts are inlined as data URIs, and scripts, frames
+// and event handlers are stripped. Requests are made with ctx, so a
+// lib.WARCRecorder attached to it sees every fetch.
+func Archive(ctx context.Context, u string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", u, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8")
+
+	r, err := lib.UseProxy(req)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Body.Close()
+
+	if r.StatusCode >= 400 {
+		return nil, fmt.Errorf("HTTP %d", r.StatusCode)
+	}
+	base, _ := url.Parse(u)
+	if r.Request != nil && r.Request.URL != nil {
+		base = r.Request.URL
+	}
+
+	b, err := lib.ReadHTML(r)
+	if err != nil {
+		return nil, err
+	}
+	doc, err := goquery.NewDocumentFromReader(bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+	if v, ok := doc.Find("base[href]").First().Attr("href"); ok {
+		if ref, err := url.Parse(v); err == nil {
+			base = base.ResolveReference(ref)
+		}
+	}
+
+	a := &archiver{ctx: ctx, budget: archiveMaxSize(), cache: map[string]string{}}
+	a.page(doc, base)
+
+	out, err := doc.Html()
+	if err != nil {
+		return nil, err
+	}
+	return []byte(out), nil
+}
+
+func (a *archiver) page(doc *goquery.Document, base *url.URL) {
+	doc.Find("script, noscript, iframe, frame, frameset, object, embed, applet, base, template, portal").Remove()
+	doc.Find(`meta[http-equiv]`).Each(func(_ int, s *goquery.Selection) {
+		switch strings.ToLower(s.AttrOr("http-equiv", "")) {
+		case "refresh", "content-security-policy", "set-cookie":
+			s.Remove()
+		}
+	})
+	doc.Find("picture source").Remove()
+
+	doc.Find("style").Each(func(_ int, s *goquery.Selection) {
+		setStyle(s, a.css(s.Text(), base, 0))
+	})
+	doc.Find("[style]").Each(func(_ int, s *goquery.Selection) {
+		s.SetAttr("style", a.css(s.AttrOr("style", ""), base, 0))
+	})
+
+	doc.Find("link").Each(func(_ int, s *goquery.Selection) {
+		rel := strings.ToLower(s.AttrOr("rel", ""))
+		href := s.AttrOr("href", "")
+		switch {
+		case strings.Contains(rel, "stylesheet") && !strings.Contains(rel, "alternate"):
+			css, err := a.fetch(resolve(base, href), "text/css,*/*;q=0.1")
+			if err != nil {
+				s.Remove()
+				return
+			}
+			style := `<style></style>`
+			if media := s.AttrOr("media", ""); media != "" && media != "all" {
+				style = fmt.Sprintf(`<style media=%q></style>`, media)
+			}
+			s.BeforeHtml(style)
+			setStyle(s.Prev(), a.css(string(css.body), css.url, 0))
+			s.Remove()
+		case strings.Contains(rel, "icon"):
+			s.SetAttr("href", a.inline(resolve(base, href), "image/*"))
+		default:
+			s.Remove()
+		}
+	})
+
+	doc.Find("img, input[type=image]").Each(func(_ int, s *goquery.Selection) {
+		src := s.AttrOr("src", "")
+		for _, lazy := range []string{"data-src", "data-lazy-src", "data-original"} {
+			if v := s.AttrOr(lazy, ""); v != "" && (src == "" || strings.HasPrefix(src, "data:")) {
+				src = v
+			}
+		}
+		if src == "" {
+			if set := s.AttrOr("srcset", s.AttrOr("data-srcset", "")); set != "" {
+				if f := strings.Fields(strings.Split(set, ",")[0]); len(f) > 0 {
+					src = f[0]
+				}
+			}
+		}
+		s.RemoveAttr("srcset")
+		s.RemoveAttr("data-srcset")
+		s.RemoveAttr("loading")
+		if src != "" && !strings.HasPrefix(src, "data:") {
+			s.SetAttr("src", a.inline(resolve(base, src), "image/*"))
+		}
+	})
+	doc.Find("video[poster]").Each(func(_ int, s *goquery.Selection) {
+		s.SetAttr("poster", a.inline(resolve(base, s.AttrOr("poster", "")), "image/*"))
+	})
+
+	// what can't be inlined at least keeps pointing at the original
+	doc.Find("[href], [src], [action]").Each(func(_ int, s *goquery.Selection) {
+		for _, attr := range []string{"href", "src", "action"} {
+			if v, ok := s.Attr(attr); ok && !strings.HasPrefix(v, "data:") && !strings.HasPrefix(v, "#") {
+				if abs := resolve(base, v); abs != "" {
+					s.SetAttr(attr, abs)
+				} else {
+					s.RemoveAttr(attr)
+				}
+			}
+		}
+	})
+
+	doc.Find("*").Each(func(_ int, s *goquery.Selection) {
+		n := s.Get(0)
+		attrs := n.Attr[:0]
+		for _, at := range n.Attr {
+			if strings.HasPrefix(strings.ToLower(at.Key), "on") {
+				continue
+			}
+			attrs = append(attrs, at)
+		}
+		n.Attr = attrs
+	})
+
+	head := doc.Find("head").First()
+	head.Find("meta[charset]").Remove()
+	head.PrependHtml(fmt.Sprintf(`<meta charset="utf-8"><meta name="dotpen:archived-from" content=%q>`, base.String()))
+}
+
+// setStyle replaces the contents of a <style> element with css, which must
+// not be able to close the element it lands in.
+func setStyle(s *goquery.Selection, css string) {
+	n := s.Get(0)
+	for n.FirstChild != nil {
+		n.RemoveChild(n.FirstChild)
+	}
+	n.AppendChild(&html.Node{Type: html.TextNode, Data: strings.ReplaceAll(css, "</", `<\/`)})
+}
+
+// css inlines the fonts, images and imports referenced by a stylesheet
+// fetched from base.
+func (a *archiver) css(css string, base *url.URL, depth int) string {
+	css = rxCSSImport.ReplaceAllStringFunc(css, func(m string) string {
+		sub := rxCSSImport.FindStringSubmatch(m)
+		if depth >= 3 {
+			return ""
+		}
+		imp, err := a.fetch(resolve(base, sub[1]), "text/css,*/*;q=0.1")
+		if err != nil {
+			return ""
+		}
+		inner := a.css(string(imp.body), imp.url, depth+1)
+		if media := strings.TrimSpace(sub[2]); media != "" {
+			return fmt.Sprintf("@media %s {%s}", media, inner)
+		}
+		return inner
+	})
+
+	return rxCSSURL.ReplaceAllStringFunc(css, func(m string) string {
+		sub := rxCSSURL.FindStringSubmatch(m)
+		ref := strings.TrimSpace(sub[1] + sub[2] + sub[3])
+		if ref == "" || strings.HasPrefix(ref, "data:") || strings.HasPrefix(ref, "#") {
+			return m
+		}
+		return fmt.Sprintf("url(%q)", a.inline(resolve(base, ref), "*/*"))
+	})
+}
+
+// inline returns u as a data URI, or u itself when it can't be fetched or
+// doesn't fit the remaining budget.
+func (a *archiver) inline(u string, accept string) string {
+	if u == "" {
+		return ""
+	}
+	if v, ok := a.cache[u]; ok {
+		return v
+	}
+
+	a.cache[u] = u
+	res, err := a.fetch(u, accept)
+	if err != nil {
+		return u
+	}
+	v := "data:" + res.mime + ";base64," + base64.StdEncoding.EncodeToString(res.body)
+	a.cache[u] = v
+	return v
+}
+
+type archiveResource struct {
+	url  *url.URL
+	mime string
+	body []byte
+}
+
+var errArchiveBudget = errors.New("archive size budget exhausted")
+
+func (a *archiver) fetch(u string, accept string) (*archiveResource, error) {
+	if u == "" {
+		return nil, fmt.Errorf("empty url")
+	}
+	if a.budget <= 0 {
+		return nil, errArchiveBudget
+	}
+
+	req, err := http.NewRequestWithContext(a.ctx, "GET", u, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", accept)
+
+	r, err := lib.UseProxy(lib.Asset(req))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Body.Close()
+
+	if r.StatusCode >= 400 {
+		return nil, fmt.Errorf("HTTP %d", r.StatusCode)
+	}
+
+	limit := min(maxAssetSize, a.budget)
+	b, err := io.ReadAll(io.LimitReader(r.Body, int64(limit)+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(b) > limit {
+		return nil, errArchiveBudget
+	}
+	a.budget -= len(b)
+
+	mt, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mt == "" || mt == "application/octet-stream" || mt == "text/plain" {
+		mt = mimetype.Detect(b).String()
+		if i := strings.Index(mt, ";"); i >= 0 {
+			mt = mt[:i]
+		}
+	}
+
+	final := req.URL
+	if r.Request != nil && r.Request.URL != nil {
+		final = r.Request.URL
+	}
+	return &archiveResource{url: final, mime: mt, body: b}, nil
+}
+
+// resolve makes ref absolute against base, dropping anything not http(s).
+func resolve(base *url.URL, ref string) string {
+	ref = strings.TrimSpace(ref)
+	if ref == "" {
+		return ""
+	}
+	return safeURL(base, ref)
+}
+
+// ArchiveBookmark stores an archived copy of the bookmark's page on its
+// archive field, and the raw HTTP exchanges behind it as a WARC file on its
+// warc field.
+func ArchiveBookmark(app core.App, bookmark *core.Record) error {
+	rec := lib.NewWARCRecorder()
+	b, err := Archive(lib.WithRecorder(context.Background(), rec), bookmark.GetString("link"))
+	if err != nil {
+		return err
+	}
+
+	f, err := filesystem.NewFileFromBytes(b, "archive.html")
+	if err != nil {
+		return err
+	}
+
+	var warc bytes.Buffer
+	if _, err := rec.WriteTo(&warc); err != nil {
+		return err
+	}
+	wf, err := filesystem.NewFileFromBytes(warc.Bytes(), "archive.warc.gz")
+	if err != nil {
+		return err
+	}
+
+	// the page may have taken a while, don't overwrite edits made meanwhile
+	fresh, err := app.FindRecordById("bookmarks", bookmark.Id)
+	if err != nil {
+		return err
+	}
+	fresh.Set("archive", f)
+	fresh.Set("warc", wf)
+	return app.Save(fresh)
+}
+
+// findOwnedBookmark returns the bookmark id if it belongs to user.
+func findOwnedBookmark(app core.App, id string, user *core.Record) *core.Record {
+	bookmark, err := app.FindRecordById("bookmarks", id)
+	if err != nil {
+		return nil
+	}
+	if owner, err := BookmarkOwner(app, bookmark); err != nil || owner != user.Id {
+		return nil
+	}
+	return bookmark
+}
+
+// UseArchive serves the archived copy of a bookmark owned by user.
+func UseArchive(w http.ResponseWriter, r *http.Request, app core.App, user *core.Record) {
+	bookmark := findOwnedBookmark(app, r.PathValue("id"), user)
+	if bookmark == nil {
+		w.Header().Set("Content-Type", "application/json")
+		http.Error(w, `{"error":"Bookmark not found"}`, 404)
+		return
+	}
+
+	name := bookmark.GetString("archive")
+	if name == "" {
+		w.Header().Set("Content-Type", "application/json")
+		http.Error(w, `{"error":"No archive"}`, 404)
+		return
+	}
+
+	fsys, err := app.NewFilesystem()
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		http.Error(w, `{"error":"failed"}`, 500)
+		return
+	}
+	defer fsys.Close()
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Content-Disposition", "inline; filename=archive.html")
+	w.Header().Set("Content-Security-Policy", archiveCSP)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Cache-Control", "private, max-age=86400")
+
+	if err := fsys.Serve(w, r, bookmark.BaseFilesPath()+"/"+name, name); err != nil {
+		app.Logger().Warn("GET /api/bookmarks/{id}/archive: Serve", "id", bookmark.Id, "error", err.Error())
+		w.Header().Set("Content-Type", "application/json")
+		http.Error(w, `{"error":"failed"}`, 500)
+	}
+}
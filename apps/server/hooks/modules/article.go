@@ -0,0 +1,57 @@
+package modules
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// maxArticleText and maxArticleHTML are the limits of the bookmark's text
+// (in characters) and content (in bytes) fields.
+const (
+	maxArticleText = 1000000
+	maxArticleHTML = core.DefaultEditorFieldMaxSize
+)
+
+// applyArticle stores a on a bookmark record, cut down to the field limits
+// so a long page can't fail the save.
+func applyArticle(record *core.Record, a *Article) {
+	content := a.HTML
+	if int64(len(content)) > maxArticleHTML {
+		// the sanitizer escapes '>' outside of tags, so this cuts after the
+		// last complete tag and browsers close the open ones
+		content = content[:maxArticleHTML]
+		content = content[:strings.LastIndexByte(content, '>')+1]
+	}
+
+	record.Set("content", content)
+	record.Set("text", truncate(a.Text, maxArticleText))
+	record.Set("words", a.Words)
+}
+
+// UseArticle serves the readable article stored on a bookmark owned by
+// user. The fields are hidden so listing bookmarks doesn't send them.
+func UseArticle(w http.ResponseWriter, r *http.Request, app core.App, user *core.Record) {
+	bookmark := findOwnedBookmark(app, r.PathValue("id"), user)
+	if bookmark == nil {
+		w.Header().Set("Content-Type", "application/json")
+		http.Error(w, `{"error":"Bookmark not found"}`, 404)
+		return
+	}
+
+	if bookmark.GetString("text") == "" {
+		w.Header().Set("Content-Type", "application/json")
+		http.Error(w, `{"error":"No article"}`, 404)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "private, no-cache")
+	_ = json.NewEncoder(w).Encode(Article{
+		HTML:  bookmark.GetString("content"),
+		Text:  bookmark.GetString("text"),
+		Words: bookmark.GetInt("words"),
+	})
+}
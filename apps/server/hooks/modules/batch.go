@@ -0,0 +1,134 @@
+package modules
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+
+	"dotpen.co/server/hooks/lib"
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// maxBatchURLs caps the number of links one batch request may crawl.
+const maxBatchURLs = 500
+
+type BatchRequest struct {
+	URLs []string `json:"urls"`
+}
+
+type BatchResult struct {
+	URL   string    `json:"url"`
+	Data  *MetaData `json:"data,omitempty"`
+	Error string    `json:"error,omitempty"`
+}
+
+// UseCrawlBatch crawls a list of URLs and streams one BatchResult per line
+// (application/x-ndjson) as soon as each one finishes, so results arrive in
+// completion order rather than request order. At most CRAWL_BATCH_CONCURRENCY
+// (default 8) URLs are crawled at once, and no more than
+// CRAWL_HOST_CONCURRENCY (default 2) of them against the same host.
+func UseCrawlBatch(w http.ResponseWriter, r *http.Request, app core.App) {
+	body := &BatchRequest{}
+	if err := json.NewDecoder(io.LimitReader(r.Body, 1<<20)).Decode(body); err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		http.Error(w, `{"error":"Invalid body"}`, 400)
+		return
+	}
+	if len(body.URLs) == 0 {
+		w.Header().Set("Content-Type", "application/json")
+		http.Error(w, `{"error":"URLs required"}`, 400)
+		return
+	}
+	if len(body.URLs) > maxBatchURLs {
+		w.Header().Set("Content-Type", "application/json")
+		http.Error(w, fmt.Sprintf(`{"error":"At most %d URLs per batch"}`, maxBatchURLs), 400)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(200)
+
+	rc := http.NewResponseController(w)
+	enc := json.NewEncoder(w)
+	var wmu sync.Mutex
+	emit := func(res *BatchResult) {
+		wmu.Lock()
+		defer wmu.Unlock()
+		_ = enc.Encode(res)
+		_ = rc.Flush()
+	}
+
+	perHost := envInt("CRAWL_HOST_CONCURRENCY", 2)
+	var hmu sync.Mutex
+	hosts := map[string]chan struct{}{}
+	hostSlot := func(u string) chan struct{} {
+		host := ""
+		if cu, err := lib.Canonicalize(u); err == nil {
+			if pu, err := url.Parse(cu); err == nil {
+				host = strings.TrimPrefix(pu.Hostname(), "www.")
+			}
+		}
+		hmu.Lock()
+		defer hmu.Unlock()
+		if hosts[host] == nil {
+			hosts[host] = make(chan struct{}, perHost)
+		}
+		return hosts[host]
+	}
+
+	sem := make(chan struct{}, envInt("CRAWL_BATCH_CONCURRENCY", 8))
+	var wg sync.WaitGroup
+
+	// the host slot is taken first, so a batch of mostly one host leaves the
+	// global slots to the others
+	ctx := r.Context()
+	for _, u := range body.URLs {
+		wg.Add(1)
+		go func(u string) {
+			defer wg.Done()
+
+			slot := hostSlot(u)
+			select {
+			case <-ctx.Done():
+				return
+			case slot <- struct{}{}:
+			}
+			defer func() { <-slot }()
+
+			select {
+			case <-ctx.Done():
+				return
+			case sem <- struct{}{}:
+			}
+			defer func() { <-sem }()
+
+			emit(crawlOne(app, u))
+		}(u)
+	}
+	wg.Wait()
+
+	app.Logger().Debug("POST /api/crawl/batch", "urls", len(body.URLs), "canceled", ctx.Err() != nil)
+}
+
+func crawlOne(app core.App, u string) (res *BatchResult) {
+	res = &BatchResult{URL: u}
+	defer func() {
+		if rec := recover(); rec != nil {
+			app.Logger().Warn("POST /api/crawl/batch: Panic", "url", u, "error", fmt.Sprint(rec))
+			res = &BatchResult{URL: u, Error: "Internal error"}
+		}
+	}()
+
+	m, err := Crawl(app, u)
+	res.Data = m
+	if err != nil {
+		res.Error = err.Error()
+	}
+	return res
+}
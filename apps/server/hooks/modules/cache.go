@@ -0,0 +1,138 @@
+package modules
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"os"
+	"time"
+
+	"dotpen.co/server/hooks/lib"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tools/types"
+)
+
+// CrawlCacheTTL is how long a crawl_cache entry is served without being
+// revalidated, configured through CRAWL_CACHE_TTL (default 24h).
+func CrawlCacheTTL() time.Duration {
+	if d, err := time.ParseDuration(os.Getenv("CRAWL_CACHE_TTL")); err == nil && d > 0 {
+		return d
+	}
+	return 24 * time.Hour
+}
+
+// cacheGet returns the crawl_cache record for key and its MetaData, or nils
+// on a miss.
+func cacheGet(app core.App, key string) (*core.Record, *MetaData) {
+	rec, err := app.FindFirstRecordByData("crawl_cache", "url", key)
+	if err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			app.Logger().Warn("Crawl: Cache lookup", "url", key, "error", err.Error())
+		}
+		return nil, nil
+	}
+
+	m := &MetaData{}
+	if err := rec.UnmarshalJSONField("data", m); err != nil {
+		return rec, nil
+	}
+	m.Status = rec.GetInt("status")
+	m.ETag = rec.GetString("etag")
+	m.LastModified = rec.GetString("last_modified")
+	return rec, m
+}
+
+// cacheLookup returns the cached MetaData for key if it is fresh, or could be
+// revalidated at the origin.
+func cacheLookup(app core.App, key string) *MetaData {
+	rec, m := cacheGet(app, key)
+	if m == nil {
+		return nil
+	}
+	if cacheFresh(rec) {
+		return m
+	}
+	return cacheRevalidate(app, rec, m, key)
+}
+
+func cacheFresh(rec *core.Record) bool {
+	return rec.GetDateTime("fetched").Time().Add(CrawlCacheTTL()).After(time.Now())
+}
+
+// cacheRevalidate asks the origin whether a stale entry changed, using the
+// stored ETag and Last-Modified. On a 304 the entry is refreshed in place, on
+// a 200 it is replaced by the page that came with it.
+func cacheRevalidate(app core.App, rec *core.Record, m *MetaData, u string) *MetaData {
+	etag, modified := rec.GetString("etag"), rec.GetString("last_modified")
+	if etag == "" && modified == "" {
+		return nil
+	}
+
+	req, err := http.NewRequest("GET", u, nil)
+	if err != nil {
+		return nil
+	}
+	req.Header.Set("Accept", "text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8")
+	if etag != "" {
+		req.Header.Set("If-None-Match", etag)
+	}
+	if modified != "" {
+		req.Header.Set("If-Modified-Since", modified)
+	}
+
+	r, err := lib.UseProxy(req)
+	if err != nil {
+		return nil
+	}
+	defer r.Body.Close()
+
+	switch r.StatusCode {
+	case http.StatusNotModified:
+		rec.Set("fetched", types.NowDateTime())
+		if err := app.Save(rec); err != nil {
+			app.Logger().Warn("Crawl: Cache revalidate", "url", u, "error", err.Error())
+		}
+		return m
+	case http.StatusOK:
+		fresh, err := usePage(u, r)
+		if err != nil {
+			return nil
+		}
+		fresh.URL = m.URL
+		if fresh.Article != nil {
+			fresh.Words = fresh.Article.Words
+		}
+		if fresh.Canonical == "" {
+			fresh.Canonical = fresh.URL
+		}
+		if !fresh.NoCache {
+			cacheSet(app, u, fresh)
+		}
+		return fresh
+	}
+	return nil
+}
+
+// cacheSet stores m under key, updating the entry when it already exists.
+func cacheSet(app core.App, key string, m *MetaData) {
+	rec, _ := cacheGet(app, key)
+	if rec == nil {
+		collection, err := app.FindCachedCollectionByNameOrId("crawl_cache")
+		if err != nil {
+			app.Logger().Warn("Crawl: Cache store", "url", key, "error", err.Error())
+			return
+		}
+		rec = core.NewRecord(collection)
+		rec.Set("url", key)
+	}
+
+	rec.Set("data", m)
+	rec.Set("status", m.Status)
+	rec.Set("etag", m.ETag)
+	rec.Set("last_modified", m.LastModified)
+	rec.Set("fetched", types.NowDateTime())
+
+	if err := app.Save(rec); err != nil {
+		app.Logger().Warn("Crawl: Cache store", "url", key, "error", err.Error())
+	}
+}
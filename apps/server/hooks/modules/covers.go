@@ -0,0 +1,263 @@
+package modules
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"io"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/HugoSmits86/nativewebp"
+	"github.com/buckket/go-blurhash"
+	"github.com/disintegration/imaging"
+	"github.com/gabriel-vasile/mimetype"
+	validation "github.com/go-ozzo/ozzo-validation/v4"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tools/filesystem"
+)
+
+// coverTypes are the image types accepted as a bookmark cover. SVGs are
+// stored as they are, without variants.
+var coverTypes = []string{"image/jpeg", "image/png", "image/gif", "image/webp", "image/avif", "image/bmp", "image/tiff", "image/svg+xml"}
+
+const (
+	// maxCoverPixels keeps decompression bombs from being decoded.
+	maxCoverPixels = 40_000_000
+	// maxVariantAspect caps the height of variants at twice their width; taller
+	// covers are cropped from the top.
+	maxVariantAspect = 2
+	// variantJPEGQuality is used for variants of opaque covers.
+	variantJPEGQuality = 82
+)
+
+// coverWidths are the widths of the variants made of a cover, configured
+// through the comma separated COVER_WIDTHS (default 320,640,1280).
+func coverWidths() []int {
+	var widths []int
+	for _, v := range strings.Split(os.Getenv("COVER_WIDTHS"), ",") {
+		if w, err := strconv.Atoi(strings.TrimSpace(v)); err == nil && w > 0 {
+			widths = append(widths, w)
+		}
+	}
+	if len(widths) == 0 {
+		return []int{320, 640, 1280}
+	}
+	slices.Sort(widths)
+	return widths
+}
+
+// CheckCover checks the type of a cover uploaded with a bookmark save, and
+// clears the variants, BlurHash, color and size of the previous one; removing
+// the cover clears them too. It reports whether the new cover is left for
+// ProcessCover, which runs on the crawl queue so a large upload doesn't hold
+// up the save.
+func CheckCover(bookmark *core.Record) (bool, error) {
+	files := bookmark.GetUnsavedFiles("cover")
+	if len(files) == 0 {
+		if bookmark.GetString("cover") == "" && bookmark.GetString("cover_blurhash") != "" {
+			clearCover(bookmark)
+		}
+		return false, nil
+	}
+
+	r, err := files[len(files)-1].Reader.Open()
+	if err != nil {
+		return false, err
+	}
+	defer r.Close()
+
+	mt, err := mimetype.DetectReader(r)
+	if err != nil {
+		return false, err
+	}
+	if !slices.ContainsFunc(coverTypes, mt.Is) {
+		return false, validation.Errors{
+			"cover": validation.NewError("validation_invalid_cover_type", "The cover must be an image"),
+		}
+	}
+
+	clearCover(bookmark)
+	return !mt.Is("image/svg+xml"), nil
+}
+
+// ProcessCover stores the resized variants (named cover_<width>w), BlurHash,
+// dominant color and size of a bookmark's cover along with it. Covers that
+// can't be decoded are kept without them.
+func ProcessCover(app core.App, bookmark *core.Record) error {
+	name := bookmark.GetString("cover")
+	b, err := storedFile(app, bookmark, "cover")
+	if err != nil || b == nil {
+		return err
+	}
+
+	if cfg, _, err := image.DecodeConfig(bytes.NewReader(b)); err != nil || cfg.Width*cfg.Height > maxCoverPixels {
+		app.Logger().Debug("Cover: Skipped", "id", bookmark.Id)
+		return nil
+	}
+	img, err := imaging.Decode(bytes.NewReader(b), imaging.AutoOrientation(true))
+	if err != nil {
+		app.Logger().Debug("Cover: Decode", "id", bookmark.Id, "error", err.Error())
+		return nil
+	}
+
+	maxFiles, maxSize := 0, int64(0)
+	if f, ok := bookmark.Collection().Fields.GetByName("cover_variants").(*core.FileField); ok {
+		maxFiles, maxSize = f.MaxSelect, f.MaxSize
+	}
+	variants, err := coverVariants(img, maxFiles, maxSize)
+	if err != nil {
+		app.Logger().Warn("Cover: Variants", "id", bookmark.Id, "error", err.Error())
+		return nil
+	}
+
+	// the placeholders come from a thumbnail, which is plenty for both
+	thumb := imaging.Fit(img, 32, 32, imaging.Box)
+	x, y := 4, 3
+	if img.Bounds().Dy() > img.Bounds().Dx() {
+		x, y = 3, 4
+	}
+	hash, _ := blurhash.Encode(x, y, thumb)
+
+	// don't put the variants of a replaced cover on the new one
+	bookmark, err = app.FindRecordById("bookmarks", bookmark.Id)
+	if err != nil || bookmark.GetString("cover") != name {
+		return nil
+	}
+
+	bookmark.Set("cover_variants", variants)
+	bookmark.Set("cover_blurhash", hash)
+	bookmark.Set("cover_color", dominantColor(thumb))
+	bookmark.Set("cover_width", img.Bounds().Dx())
+	bookmark.Set("cover_height", img.Bounds().Dy())
+	return app.Save(bookmark)
+}
+
+func clearCover(bookmark *core.Record) {
+	bookmark.Set("cover_variants", nil)
+	bookmark.Set("cover_blurhash", "")
+	bookmark.Set("cover_color", "")
+	bookmark.Set("cover_width", 0)
+	bookmark.Set("cover_height", 0)
+}
+
+func readUpload(f *filesystem.File) ([]byte, error) {
+	r, err := f.Reader.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return io.ReadAll(r)
+}
+
+// coverVariants resizes img to each of coverWidths narrower than it, and to
+// its own width when that's below the largest, never scaling up. Variants
+// over maxSize, or past maxFiles of them, are left out rather than failing
+// the save; zero means no limit.
+func coverVariants(img image.Image, maxFiles int, maxSize int64) ([]*filesystem.File, error) {
+	src := img.Bounds().Dx()
+	widths := []int{}
+	for _, w := range coverWidths() {
+		if w < src {
+			widths = append(widths, w)
+		}
+	}
+	if len(widths) < len(coverWidths()) {
+		widths = append(widths, src)
+	}
+
+	var files []*filesystem.File
+	for _, w := range widths {
+		if maxFiles > 0 && len(files) >= maxFiles {
+			break
+		}
+
+		resized := img
+		if w != src {
+			resized = imaging.Resize(img, w, 0, imaging.Lanczos)
+		}
+		if h := resized.Bounds().Dy(); h > w*maxVariantAspect {
+			resized = imaging.CropAnchor(resized, w, w*maxVariantAspect, imaging.Top)
+		}
+
+		b, ext, err := encodeVariant(resized)
+		if err != nil {
+			return nil, err
+		}
+		if maxSize > 0 && int64(len(b)) > maxSize {
+			continue
+		}
+		f, err := filesystem.NewFileFromBytes(b, fmt.Sprintf("cover_%dw%s", w, ext))
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, f)
+	}
+	return files, nil
+}
+
+// encodeVariant encodes img as JPEG or lossless WebP, whichever is smaller:
+// photos come out far smaller as JPEG, flat graphics as WebP. Covers with
+// transparency are always WebP. There is no lossy WebP encoder in pure Go,
+// and the server is built without cgo.
+func encodeVariant(img image.Image) ([]byte, string, error) {
+	var webp bytes.Buffer
+	if err := nativewebp.Encode(&webp, img, nil); err != nil {
+		return nil, "", err
+	}
+	if o, ok := img.(interface{ Opaque() bool }); !ok || !o.Opaque() {
+		return webp.Bytes(), ".webp", nil
+	}
+
+	var jpg bytes.Buffer
+	if err := jpeg.Encode(&jpg, img, &jpeg.Options{Quality: variantJPEGQuality}); err != nil {
+		return nil, "", err
+	}
+	if jpg.Len() < webp.Len() {
+		return jpg.Bytes(), ".jpg", nil
+	}
+	return webp.Bytes(), ".webp", nil
+}
+
+// dominantColor returns the most common color of img as #rrggbb. Pixels are
+// bucketed by their top 4 bits per channel, and the winning bucket averaged;
+// transparent pixels don't count.
+func dominantColor(img image.Image) string {
+	type bucket struct{ n, r, g, b int }
+	buckets := map[int]*bucket{}
+	var best *bucket
+
+	bounds := img.Bounds()
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			r, g, b, a := img.At(x, y).RGBA()
+			if a < 0x8000 {
+				continue
+			}
+			// back to straight 8 bit values
+			r, g, b = r*0xFF/a, g*0xFF/a, b*0xFF/a
+
+			key := int(r>>4)<<8 | int(g>>4)<<4 | int(b>>4)
+			bk := buckets[key]
+			if bk == nil {
+				bk = &bucket{}
+				buckets[key] = bk
+			}
+			bk.n++
+			bk.r += int(r)
+			bk.g += int(g)
+			bk.b += int(b)
+			if best == nil || bk.n > best.n {
+				best = bk
+			}
+		}
+	}
+
+	if best == nil {
+		return ""
+	}
+	return fmt.Sprintf("#%02x%02x%02x", best.r/best.n, best.g/best.n, best.b/best.n)
+}
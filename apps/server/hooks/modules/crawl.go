@@ -1,18 +1,18 @@
 package modules
 
 import (
+	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"net/url"
 	"regexp"
 	"strings"
-	"time"
 
 	"dotpen.co/server/hooks/lib"
 	"github.com/PuerkitoBio/goquery"
-	"github.com/patrickmn/go-cache"
 	"github.com/pocketbase/pocketbase/core"
 )
 
@@ -22,11 +22,71 @@ type MetaData struct {
 	Image       string `json:"image,omitempty"`
 	Favicon     string `json:"favicon,omitempty"`
 	URL         string `json:"url,omitempty"`
+	Canonical   string `json:"canonical,omitempty"`
 	Author      string `json:"author,omitempty"`
 	Date        string `json:"date,omitempty"`
+	Embed       string `json:"embed,omitempty"`
+
+	// Icons and Manifest are the favicon candidates ResolveFavicon picks from
+	Icons    []Icon `json:"icons,omitempty"`
+	Manifest string `json:"manifest,omitempty"`
+
+	SiteName string       `json:"site_name,omitempty"`
+	OGType   string       `json:"og_type,omitempty"`
+	Lang     string       `json:"lang,omitempty"`
+	Tags     []string     `json:"tags,omitempty"`
+	Twitter  *TwitterCard `json:"twitter,omitempty"`
+	// ReadingTime is the estimated time to read, or watch, in minutes
+	ReadingTime int `json:"reading_time,omitempty"`
+
+	// Type is what the link points at: page, pdf, image, audio, video or file
+	Type     string  `json:"type,omitempty"`
+	Width    int     `json:"width,omitempty"`
+	Height   int     `json:"height,omitempty"`
+	Pages    int     `json:"pages,omitempty"`
+	Duration float64 `json:"duration,omitempty"` // seconds
+	Codec    string  `json:"codec,omitempty"`
+
+	// Article is stored on the bookmark only, it is too large to go into
+	// every response and cache entry; Words tells a page has one
+	Article *Article `json:"-"`
+	Words   int      `json:"words,omitempty"`
+
+	// filled in by site specific crawlers
+	Repo       *Repository `json:"repo,omitempty"`
+	Issue      *Issue      `json:"issue,omitempty"`
+	Discussion *Discussion `json:"discussion,omitempty"`
+	Post       *Post       `json:"post,omitempty"`
+
+	// response validators, kept in crawl_cache for conditional revalidation
+	Status       int    `json:"-"`
+	ETag         string `json:"-"`
+	LastModified string `json:"-"`
+	// NoCache keeps results read with the server's credentials out of
+	// crawl_cache
+	NoCache bool `json:"-"`
 }
 
-var c = cache.New(10*time.Minute, 30*time.Minute)
+// TwitterCard holds the twitter:* meta tags of a page.
+type TwitterCard struct {
+	Card        string `json:"card,omitempty"`
+	Site        string `json:"site,omitempty"`
+	Creator     string `json:"creator,omitempty"`
+	Title       string `json:"title,omitempty"`
+	Description string `json:"description,omitempty"`
+	Image       string `json:"image,omitempty"`
+	Player      string `json:"player,omitempty"`
+}
+
+// maxTags caps the tags taken from a page, as some stuff their keywords.
+const maxTags = 20
+
+func init() {
+	RegisterCrawler(&HostCrawler{Key: "twitter", Hosts: []string{"twitter.com", "x.com", "t.co"}, Use: UseTwitter})
+	RegisterCrawler(&HostCrawler{Key: "youtube", Hosts: []string{"youtube.com", "m.youtube.com", "youtu.be"}, Use: UseYouTube})
+	RegisterCrawler(&HostCrawler{Key: "tiktok", Hosts: []string{"tiktok.com"}, Use: UseTikTok})
+	RegisterCrawler(&HostCrawler{Key: "spotify", Hosts: []string{"open.spotify.com", "spotify.com"}, Use: UseSpotify})
+}
 
 func UseCrawl(w http.ResponseWriter, r *http.Request, app core.App) {
 	w.Header().Set("Content-Type", "application/json")
@@ -42,117 +102,241 @@ func UseCrawl(w http.ResponseWriter, r *http.Request, app core.App) {
 		http.Error(w, `{"error":"URL required"}`, 400)
 		return
 	}
-	if !strings.HasPrefix(u, "http") {
-		u = "https://" + u
-	}
-	if v, f := c.Get(u); f {
-		_ = json.NewEncoder(w).Encode(v)
+
+	m, _ := Crawl(app, u)
+	if m == nil {
+		http.Error(w, `{"error":"failed"}`, 500)
 		return
 	}
+	_ = json.NewEncoder(w).Encode(m)
+}
+
+// Crawl returns the MetaData for u, served from the crawl cache when the
+// entry is fresh or still valid at the origin, and crawled otherwise. The
+// cache is keyed by canonical URL, so variants of one link share an entry.
+//
+// When every crawler fails, Crawl returns a placeholder built from the host
+// name together with the error, so callers can choose to retry.
+func Crawl(app core.App, u string) (*MetaData, error) {
+	return crawl(app, u, true)
+}
+
+// CrawlArticle is Crawl for callers storing the article on a bookmark. The
+// article is left out of crawl_cache, so a cached page that has one is
+// crawled again.
+func CrawlArticle(app core.App, u string) (*MetaData, error) {
+	m, err := Crawl(app, u)
+	if err != nil || m.Article != nil || m.Words == 0 {
+		return m, err
+	}
+	return crawl(app, u, false)
+}
+
+func crawl(app core.App, u string, cached bool) (*MetaData, error) {
+	m, err := crawlPage(app, u, cached)
+	if err != nil {
+		return m, err
+	}
+
+	if pu, perr := url.Parse(u); perr == nil && pu.Fragment != "" {
+		if mu, perr := url.Parse(m.URL); perr == nil {
+			if fc, ok := FindCrawler(mu).(FragmentCrawler); ok {
+				fc.Fragment(m, pu.Fragment)
+			}
+		}
+	}
+	return m, nil
+}
+
+func crawlPage(app core.App, u string, cached bool) (*MetaData, error) {
+	u, err := lib.Canonicalize(u)
+	if err != nil {
+		return nil, err
+	}
+
+	lookup := func(key string) *MetaData {
+		if !cached {
+			return nil
+		}
+		return cacheLookup(app, key)
+	}
+
+	keys := []string{u}
+	if m := lookup(u); m != nil {
+		return m, nil
+	}
+
+	if lib.IsShortener(u) {
+		if target, err := lib.Unshorten(u); err == nil {
+			u = target
+			keys = append(keys, u)
+			if m := lookup(u); m != nil {
+				cacheSet(app, keys[0], m)
+				return m, nil
+			}
+		} else {
+			app.Logger().Debug("Crawl: Unshorten", "url", u, "error", err.Error())
+		}
+	}
 
 	m, err := (func(u string) (*MetaData, error) {
 		pu, err := url.Parse(u)
 		if err != nil || pu.Host == "" {
 			return nil, fmt.Errorf("invalid url")
 		}
-		h := strings.ToLower(strings.TrimPrefix(pu.Host, "www."))
-
-		switch h {
-		case "twitter.com", "x.com", "t.co":
-			app.Logger().Debug("GET /api/crawl: Twitter crawler", "url", u)
-			return UseTwitter(u)
-		case "youtube.com", "m.youtube.com", "youtu.be":
-			app.Logger().Debug("GET /api/crawl: YouTube crawler", "url", u)
-			return UseYouTube(u)
-		case "tiktok.com", "www.tiktok.com":
-			app.Logger().Debug("GET /api/crawl: TikTok crawler", "url", u)
-			return UseTikTok(u)
-		case "open.spotify.com", "spotify.com":
-			app.Logger().Debug("GET /api/crawl: Spotify crawler", "url", u)
-			return UseSpotify(u)
-		default:
-			app.Logger().Debug("GET /api/crawl: Default crawler", "url", u)
-			return UseDefault(u)
-		}
+
+		cr := FindCrawler(pu)
+		app.Logger().Debug("Crawl: Using crawler", "crawler", cr.Name(), "url", u)
+		return cr.Fetch(u)
 	})(u)
 
 	// try last time with default (if used an specific crawler)
 	if err != nil {
-		app.Logger().Warn("GET /api/crawl: Overwriting to default crawler", "url", u, "error", err.Error())
+		app.Logger().Warn("Crawl: Overwriting to default crawler", "url", u, "error", err.Error())
 
-		m, err = UseDefault(u)
-		if err == nil {
-			m.URL = u
-			c.Set(u, m, cache.DefaultExpiration)
-			_ = json.NewEncoder(w).Encode(m)
-			return
-		} else {
-			app.Logger().Warn("GET /api/crawl: Crawl error (default)", "url", u, "error", err.Error())
-			urlwoh, err := url.Parse(u)
-			if err != nil {
-				http.Error(w, `{"error":"failed"}`, 500)
-				return
+		m, err = DefaultCrawler.Fetch(u)
+		if err != nil {
+			app.Logger().Warn("Crawl: Crawl error (default)", "url", u, "error", err.Error())
+			urlwoh, perr := url.Parse(u)
+			if perr != nil {
+				return nil, perr
 			}
 
-			if m == nil {
-				m = &MetaData{}
-			}
-			m.Title = urlwoh.Hostname()
-			m.Favicon = urlwoh.Host + "/favicon.ico"
+			// not cached, so the next request tries the site again
+			return &MetaData{
+				Title:     urlwoh.Hostname(),
+				Favicon:   urlwoh.Scheme + "://" + urlwoh.Host + "/favicon.ico",
+				URL:       u,
+				Canonical: u,
+			}, err
 		}
 	}
 
 	m.URL = u
-	c.Set(u, m, cache.DefaultExpiration)
-	_ = json.NewEncoder(w).Encode(m)
+	if m.Article != nil {
+		m.Words = m.Article.Words
+	}
+	if m.Canonical == "" {
+		m.Canonical = u
+	} else if m.Canonical != u {
+		keys = append(keys, m.Canonical)
+	}
+
+	if m.NoCache {
+		return m, nil
+	}
+	for _, key := range keys {
+		cacheSet(app, key, m)
+	}
+	return m, nil
 }
 
 func UseDefault(u string) (*MetaData, error) {
 	req, _ := http.NewRequest("GET", u, nil)
-	req.Header.Set("User-Agent", "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/137.0.0.0 Safari/537.36")
 	req.Header.Set("Accept", "text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8")
 	req.Header.Set("Accept-Language", "en-US,en;q=0.5")
 	req.Header.Set("Connection", "keep-alive")
 
 	r, err := lib.UseProxy(req)
 	if err != nil {
+		if m, oerr := useOEmbedOnly(u); oerr == nil {
+			return m, nil
+		}
 		return nil, err
 	}
 	defer r.Body.Close()
+	return usePage(u, r)
+}
 
+// usePage reads the MetaData of the page at u from its response r.
+func usePage(u string, r *http.Response) (*MetaData, error) {
 	if r.StatusCode >= 400 {
-		b, _ := io.ReadAll(r.Body)
+		b, _ := io.ReadAll(io.LimitReader(r.Body, 1024))
+		if m, oerr := useOEmbedOnly(u); oerr == nil {
+			return m, nil
+		}
 		return nil, fmt.Errorf("HTTP %d: %.100s", r.StatusCode, b)
 	}
 
-	b, _ := io.ReadAll(r.Body)
-	doc, err := goquery.NewDocumentFromReader(strings.NewReader(string(b)))
+	b, err := lib.ReadHTML(r)
+	if errors.Is(err, lib.ErrNotHTML) {
+		return useFile(u, r)
+	}
+	if err != nil {
+		return nil, err
+	}
+	doc, err := goquery.NewDocumentFromReader(bytes.NewReader(b))
 	if err != nil {
 		return nil, err
 	}
 
-	m := &MetaData{}
+	m := &MetaData{
+		Type:         "page",
+		Status:       r.StatusCode,
+		ETag:         r.Header.Get("ETag"),
+		LastModified: r.Header.Get("Last-Modified"),
+	}
 	set := func(f *string, v string) {
 		if *f == "" && v != "" {
 			*f = v
 		}
 	}
 
+	seen := map[string]bool{}
+	addTags := func(tags ...string) {
+		for _, t := range tags {
+			t = normalizeSpace(t)
+			if t == "" || seen[strings.ToLower(t)] || len(m.Tags) >= maxTags {
+				continue
+			}
+			seen[strings.ToLower(t)] = true
+			m.Tags = append(m.Tags, t)
+		}
+	}
+
+	tc := &TwitterCard{}
+	twitter := func(n, v string) {
+		switch strings.TrimPrefix(n, "twitter:") {
+		case "card":
+			set(&tc.Card, v)
+		case "site":
+			set(&tc.Site, v)
+		case "creator":
+			set(&tc.Creator, v)
+		case "title":
+			set(&tc.Title, v)
+		case "description":
+			set(&tc.Description, v)
+		case "image", "image:src":
+			set(&tc.Image, v)
+		case "player":
+			set(&tc.Player, v)
+		}
+	}
+
+	var appName, locale, contentLang string
 	doc.Find("meta").Each(func(_ int, s *goquery.Selection) {
+		v := strings.TrimSpace(s.AttrOr("content", ""))
 		if n, ok := s.Attr("name"); ok {
-			v, _ := s.Attr("content")
-			switch strings.ToLower(n) {
+			switch n = strings.ToLower(n); n {
 			case "title":
 				set(&m.Title, v)
 			case "description":
 				set(&m.Description, v)
 			case "author":
 				set(&m.Author, v)
+			case "application-name":
+				set(&appName, v)
+			case "keywords":
+				addTags(strings.Split(v, ",")...)
+			default:
+				if strings.HasPrefix(n, "twitter:") {
+					twitter(n, v)
+				}
 			}
 		}
 		if p, ok := s.Attr("property"); ok {
-			v, _ := s.Attr("content")
-			switch strings.ToLower(p) {
+			switch p = strings.ToLower(p); p {
 			case "og:title":
 				set(&m.Title, v)
 			case "og:description":
@@ -161,25 +345,43 @@ func UseDefault(u string) (*MetaData, error) {
 				set(&m.Image, v)
 			case "og:url":
 				set(&m.URL, v)
-			}
-		}
-	})
-
-	doc.Find("link").EachWithBreak(func(_ int, s *goquery.Selection) bool {
-		if r, _ := s.Attr("rel"); strings.Contains(strings.ToLower(r), "icon") {
-			href, _ := s.Attr("href")
-			if u2, err := url.Parse(href); err == nil {
-				if !u2.IsAbs() {
-					base, _ := url.Parse(u)
-					href = base.ResolveReference(u2).String()
+			case "og:site_name":
+				set(&m.SiteName, v)
+			case "og:type":
+				set(&m.OGType, strings.ToLower(v))
+			case "og:locale":
+				set(&locale, v)
+			case "article:tag":
+				addTags(v)
+			default:
+				if strings.HasPrefix(p, "twitter:") {
+					twitter(p, v)
 				}
-				m.Favicon = href
 			}
-			return false
 		}
-		return true
+		if h, ok := s.Attr("http-equiv"); ok && strings.EqualFold(h, "content-language") {
+			set(&contentLang, strings.Split(v, ",")[0])
+		}
 	})
 
+	if *tc != (TwitterCard{}) {
+		m.Twitter = tc
+		set(&m.Title, tc.Title)
+		set(&m.Description, tc.Description)
+		set(&m.Image, tc.Image)
+	}
+	set(&m.SiteName, appName)
+
+	set(&m.Lang, strings.TrimSpace(doc.Find("html").First().AttrOr("lang", "")))
+	set(&m.Lang, strings.TrimSpace(contentLang))
+	set(&m.Lang, strings.ReplaceAll(locale, "_", "-"))
+
+	m.Icons, m.Manifest = pageIcons(doc, u)
+	m.Favicon = bestDeclaredIcon(m.Icons)
+	if m.Favicon == "" {
+		m.Favicon = ResolveURL(u, "/favicon.ico")
+	}
+
 	doc.Find("script[type='application/ld+json']").Each(func(_ int, s *goquery.Selection) {
 		var d interface{}
 		if err := json.Unmarshal([]byte(s.Text()), &d); err != nil {
@@ -232,13 +434,100 @@ func UseDefault(u string) (*MetaData, error) {
 					m.Date = v
 				}
 			}
+			switch k := o["keywords"].(type) {
+			case string:
+				addTags(strings.Split(k, ",")...)
+			case []interface{}:
+				for _, v := range k {
+					if t, ok := v.(string); ok {
+						addTags(t)
+					}
+				}
+			}
 		}
 	})
 
+	m.Canonical = pageCanonical(doc, u)
+
+	endpoint := DiscoverOEmbed(doc, u)
+	if endpoint == "" {
+		endpoint = FindOEmbed(u)
+	}
+	if endpoint != "" {
+		if o, err := UseOEmbed(endpoint); err == nil {
+			o.Merge(m)
+		}
+	}
+
 	if m.Title == "" {
 		m.Title = strings.TrimSpace(doc.Find("title").First().Text())
 	}
 
+	// last, as it takes the document apart
+	m.Article = ExtractArticle(doc, u)
+	if m.Article != nil {
+		m.ReadingTime = readingTime(m.Article.Words)
+	}
+
+	return m, nil
+}
+
+// pageCanonical returns the canonical URL a page declares through
+// <link rel="canonical"> or og:url. Declarations pointing at another site, or
+// collapsing a deep link onto the home page, are ignored as misconfigured.
+func pageCanonical(doc *goquery.Document, u string) string {
+	base, err := url.Parse(u)
+	if err != nil {
+		return ""
+	}
+
+	var candidates []string
+	if v, ok := doc.Find(`link[rel="canonical"]`).First().Attr("href"); ok {
+		candidates = append(candidates, v)
+	}
+	if v, ok := doc.Find(`meta[property="og:url"]`).First().Attr("content"); ok {
+		candidates = append(candidates, v)
+	}
+
+	for _, c := range candidates {
+		ref, err := url.Parse(strings.TrimSpace(c))
+		if err != nil || c == "" {
+			continue
+		}
+		ref = base.ResolveReference(ref)
+
+		cu, err := lib.Canonicalize(ref.String())
+		if err != nil {
+			continue
+		}
+		pc, _ := url.Parse(cu)
+
+		if !strings.EqualFold(strings.TrimPrefix(pc.Hostname(), "www."), strings.TrimPrefix(base.Hostname(), "www.")) {
+			continue
+		}
+		if pc.Path == "/" && strings.Trim(base.Path, "/") != "" {
+			continue
+		}
+		return cu
+	}
+	return ""
+}
+
+// useOEmbedOnly builds MetaData from the bundled provider list alone, for
+// pages that refuse to serve their HTML.
+func useOEmbedOnly(u string) (*MetaData, error) {
+	endpoint := FindOEmbed(u)
+	if endpoint == "" {
+		return nil, fmt.Errorf("no oembed provider")
+	}
+
+	o, err := UseOEmbed(endpoint)
+	if err != nil {
+		return nil, err
+	}
+
+	m := &MetaData{}
+	o.Merge(m)
 	return m, nil
 }
 
@@ -249,14 +538,14 @@ func UseTwitter(u string) (*MetaData, error) {
 	}
 
 	if strings.Contains(pu.Path, "/status/") {
-		r, err := lib.UseProxy(&http.Request{
+		r, err := lib.UseProxy(lib.API(&http.Request{
 			URL: &url.URL{
 				Scheme:   "https",
 				Host:     "publish.twitter.com",
 				Path:     "/oembed",
 				RawQuery: "url=" + url.QueryEscape(u),
 			},
-		})
+		}))
 		if err != nil {
 			return nil, err
 		}
@@ -293,9 +582,11 @@ func UseTwitter(u string) (*MetaData, error) {
 		}
 
 		return &MetaData{
-			Title:   title,
-			Author:  d.AuthorName,
-			Favicon: favicon,
+			Title:    title,
+			Author:   d.AuthorName,
+			Favicon:  favicon,
+			SiteName: "X",
+			Type:     "page",
 		}, nil
 	}
 
@@ -316,7 +607,7 @@ func UseTwitter(u string) (*MetaData, error) {
 }
 
 func UseYouTube(u string) (*MetaData, error) {
-	resp, err := lib.UseProxy(&http.Request{URL: &url.URL{Scheme: "https", Host: "www.youtube.com", Path: "/oembed", RawQuery: "url=" + url.QueryEscape(u) + "&format=json"}})
+	resp, err := lib.UseProxy(lib.API(&http.Request{URL: &url.URL{Scheme: "https", Host: "www.youtube.com", Path: "/oembed", RawQuery: "url=" + url.QueryEscape(u) + "&format=json"}}))
 	if err == nil && resp.StatusCode == 200 {
 		defer resp.Body.Close()
 
@@ -327,10 +618,12 @@ func UseYouTube(u string) (*MetaData, error) {
 		}
 		if err := json.NewDecoder(resp.Body).Decode(&o); err == nil {
 			return &MetaData{
-				Title:  o.Title,
-				Author: o.AuthorName,
-				Image:  o.ThumbnailURL,
-				URL:    u,
+				Title:    o.Title,
+				Author:   o.AuthorName,
+				Image:    o.ThumbnailURL,
+				URL:      u,
+				SiteName: "YouTube",
+				Type:     "video",
 			}, nil
 		}
 	}
@@ -377,7 +670,7 @@ func UseYouTube(u string) (*MetaData, error) {
 }
 
 func UseTikTok(u string) (*MetaData, error) {
-	r, err := lib.UseProxy(&http.Request{URL: &url.URL{Scheme: "https", Host: "www.tiktok.com", Path: "/oembed", RawQuery: "url=" + url.QueryEscape(u)}})
+	r, err := lib.UseProxy(lib.API(&http.Request{URL: &url.URL{Scheme: "https", Host: "www.tiktok.com", Path: "/oembed", RawQuery: "url=" + url.QueryEscape(u)}}))
 	if err != nil {
 		return nil, err
 	}
@@ -393,15 +686,17 @@ func UseTikTok(u string) (*MetaData, error) {
 	}
 
 	return &MetaData{
-		Title:  d.Title,
-		Author: d.AuthorName,
-		Image:  d.ThumbnailURL,
-		URL:    u,
+		Title:    d.Title,
+		Author:   d.AuthorName,
+		Image:    d.ThumbnailURL,
+		URL:      u,
+		SiteName: "TikTok",
+		Type:     "video",
 	}, nil
 }
 
 func UseSpotify(u string) (*MetaData, error) {
-	r, err := lib.UseProxy(&http.Request{URL: &url.URL{Scheme: "https", Host: "embed.spotify.com", Path: "/oembed", RawQuery: "url=" + url.QueryEscape(u)}})
+	r, err := lib.UseProxy(lib.API(&http.Request{URL: &url.URL{Scheme: "https", Host: "embed.spotify.com", Path: "/oembed", RawQuery: "url=" + url.QueryEscape(u)}}))
 	if err != nil {
 		return nil, err
 	}
@@ -416,8 +711,10 @@ func UseSpotify(u string) (*MetaData, error) {
 	}
 
 	return &MetaData{
-		Title: d.Title,
-		Image: d.ThumbnailURL,
-		URL:   u,
+		Title:    d.Title,
+		Image:    d.ThumbnailURL,
+		URL:      u,
+		SiteName: "Spotify",
+		Type:     "audio",
 	}, nil
 }
@@ -0,0 +1,103 @@
+package modules
+
+import (
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Crawler extracts MetaData for the URLs it matches. Crawlers are consulted
+// by UseCrawl in order of priority (highest first); the first match wins.
+type Crawler interface {
+	Name() string
+	Match(u *url.URL) bool
+	Priority() int
+	Fetch(u string) (*MetaData, error)
+}
+
+// FragmentCrawler is a Crawler with a use for the fragment of a link, such as
+// a section title. Links are crawled, and cached, without their fragment, so
+// Crawl hands it over afterwards.
+type FragmentCrawler interface {
+	Crawler
+	Fragment(m *MetaData, fragment string)
+}
+
+// HostCrawler is a Crawler matched on host names and an optional path pattern.
+// Hosts are compared without a leading "www."; an entry starting with "." also
+// matches every subdomain (".example.com" matches "a.example.com").
+type HostCrawler struct {
+	Key   string
+	Hosts []string
+	Path  *regexp.Regexp
+	Rank  int
+	Use   func(u string) (*MetaData, error)
+	// OnFragment, if set, adapts the MetaData to the fragment of the link
+	OnFragment func(m *MetaData, fragment string)
+}
+
+func (c *HostCrawler) Name() string { return c.Key }
+
+func (c *HostCrawler) Priority() int { return c.Rank }
+
+func (c *HostCrawler) Fetch(u string) (*MetaData, error) { return c.Use(u) }
+
+func (c *HostCrawler) Fragment(m *MetaData, fragment string) {
+	if c.OnFragment != nil {
+		c.OnFragment(m, fragment)
+	}
+}
+
+func (c *HostCrawler) Match(u *url.URL) bool {
+	h := strings.ToLower(strings.TrimPrefix(u.Hostname(), "www."))
+
+	ok := len(c.Hosts) == 0
+	for _, e := range c.Hosts {
+		if h == e || (strings.HasPrefix(e, ".") && strings.HasSuffix(h, e)) {
+			ok = true
+			break
+		}
+	}
+	if !ok {
+		return false
+	}
+
+	return c.Path == nil || c.Path.MatchString(u.Path)
+}
+
+var (
+	crawlers   []Crawler
+	crawlersMu sync.RWMutex
+)
+
+// DefaultCrawler is used when no registered crawler matches, and as the
+// fallback when a specific crawler fails.
+var DefaultCrawler Crawler = &HostCrawler{Key: "default", Use: UseDefault}
+
+// RegisterCrawler adds c to the registry. Crawlers with equal priority keep
+// their registration order.
+func RegisterCrawler(c Crawler) {
+	crawlersMu.Lock()
+	defer crawlersMu.Unlock()
+
+	crawlers = append(crawlers, c)
+	sort.SliceStable(crawlers, func(i, j int) bool {
+		return crawlers[i].Priority() > crawlers[j].Priority()
+	})
+}
+
+// FindCrawler returns the highest priority crawler matching u, or
+// DefaultCrawler when none does.
+func FindCrawler(u *url.URL) Crawler {
+	crawlersMu.RLock()
+	defer crawlersMu.RUnlock()
+
+	for _, c := range crawlers {
+		if c.Match(u) {
+			return c
+		}
+	}
+	return DefaultCrawler
+}
@@ -0,0 +1,63 @@
+package modules
+
+import (
+	"net/url"
+	"regexp"
+	"testing"
+)
+
+func TestFindCrawler(t *testing.T) {
+	tests := []struct {
+		url  string
+		want string
+	}{
+		{"https://github.com/golang/go", "github"},
+		{"https://www.github.com/golang/go/issues/1", "github"},
+		{"https://github.com/", "default"},
+		{"https://www.reddit.com/r/golang/comments/abc123/title/", "reddit"},
+		{"https://old.reddit.com/comments/abc123", "reddit"},
+		{"https://redd.it/abc123", "reddit"},
+		{"https://news.ycombinator.com/item?id=1", "hackernews"},
+		{"https://news.ycombinator.com/news", "default"},
+		{"https://lobste.rs/s/abc123/title", "lobsters"},
+		{"https://en.wikipedia.org/wiki/Go_(programming_language)", "wikipedia"},
+		{"https://de.m.wikipedia.org/wiki/Go", "wikipedia"},
+		{"https://en.wiktionary.org/wiki/go", "wikipedia"},
+		{"https://commons.wikimedia.org/wiki/File:Cat.jpg", "wikipedia"},
+		{"https://en.wikipedia.org/w/index.php?title=Go", "default"},
+		{"https://bsky.app/profile/alice.bsky.social/post/3kabc", "bluesky"},
+		{"https://mastodon.social/@alice/110000000000000000", "activitypub"},
+		{"https://example.social/notes/9abcdef", "activitypub"},
+		{"https://www.youtube.com/watch?v=abc", "youtube"},
+		{"https://x.com/alice/status/1", "twitter"},
+		{"https://example.com/", "default"},
+	}
+	for _, tt := range tests {
+		pu, err := url.Parse(tt.url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := FindCrawler(pu).Name(); got != tt.want {
+			t.Errorf("FindCrawler(%s) = %s, want %s", tt.url, got, tt.want)
+		}
+	}
+}
+
+func TestFindCrawlerRank(t *testing.T) {
+	path := regexp.MustCompile(`^/rank`)
+	RegisterCrawler(&HostCrawler{Key: "rank-low", Hosts: []string{".rank.test"}, Path: path})
+	RegisterCrawler(&HostCrawler{Key: "rank-high", Hosts: []string{"a.rank.test"}, Path: path, Rank: 1})
+
+	for u, want := range map[string]string{
+		"https://a.rank.test/rank":     "rank-high",
+		"https://www.a.rank.test/rank": "rank-high",
+		"https://b.rank.test/rank":     "rank-low",
+		"https://rank.test/rank":       "default",
+		"https://b.rank.test/other":    "default",
+	} {
+		pu, _ := url.Parse(u)
+		if got := FindCrawler(pu).Name(); got != want {
+			t.Errorf("FindCrawler(%s) = %s, want %s", u, got, want)
+		}
+	}
+}
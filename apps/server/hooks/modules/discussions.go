@@ -0,0 +1,276 @@
+package modules
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"dotpen.co/server/hooks/lib"
+	"github.com/PuerkitoBio/goquery"
+)
+
+// Discussion is a thread on a link aggregator. Target is the link it
+// discusses, empty for text posts.
+type Discussion struct {
+	Site      string `json:"site"`
+	Community string `json:"community,omitempty"`
+	Author    string `json:"author,omitempty"`
+	Score     int    `json:"score"`
+	Comments  int    `json:"comments"`
+	Target    string `json:"target,omitempty"`
+}
+
+var (
+	rxRedditPath   = regexp.MustCompile(`^(?:/r/[^/]+)?/comments/([a-z0-9]+)`)
+	rxRedditShort  = regexp.MustCompile(`^/([a-z0-9]+)/?$`)
+	rxLobstersPath = regexp.MustCompile(`^/s/([a-z0-9]+)`)
+)
+
+func init() {
+	RegisterCrawler(&HostCrawler{Key: "reddit", Hosts: []string{"reddit.com", "old.reddit.com", "new.reddit.com", "np.reddit.com", "m.reddit.com"}, Path: rxRedditPath, Use: UseReddit})
+	RegisterCrawler(&HostCrawler{Key: "reddit", Hosts: []string{"redd.it"}, Path: rxRedditShort, Use: UseReddit})
+	RegisterCrawler(&HostCrawler{Key: "hackernews", Hosts: []string{"news.ycombinator.com"}, Path: regexp.MustCompile(`^/item$`), Use: UseHackerNews})
+	RegisterCrawler(&HostCrawler{Key: "lobsters", Hosts: []string{"lobste.rs"}, Path: rxLobstersPath, Use: UseLobsters})
+}
+
+// getJSON decodes the JSON answer of an API call to u into v.
+func getJSON(u string, v any) error {
+	req, err := http.NewRequest("GET", u, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	return decodeJSON(req, v)
+}
+
+// decodeJSON sends an API request and decodes its JSON answer into v.
+func decodeJSON(req *http.Request, v any) error {
+	r, err := lib.UseProxy(lib.API(req))
+	if err != nil {
+		return err
+	}
+	defer r.Body.Close()
+
+	if r.StatusCode != http.StatusOK {
+		return fmt.Errorf("HTTP %d", r.StatusCode)
+	}
+	return json.NewDecoder(r.Body).Decode(v)
+}
+
+// discussionTarget returns the canonical form of a post's link, or "" when
+// it only links back to the discussion itself.
+func discussionTarget(link, self string) string {
+	if link == "" {
+		return ""
+	}
+	cu, err := lib.Canonicalize(link)
+	if err != nil {
+		return ""
+	}
+	if own, err := lib.Canonicalize(self); err == nil && own == cu {
+		return ""
+	}
+	return cu
+}
+
+// htmlText turns the HTML of a post body into plain text.
+func htmlText(s string) string {
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(s))
+	if err != nil {
+		return s
+	}
+	return normalizeSpace(doc.Text())
+}
+
+// UseReddit reads a Reddit post through the .json variant of its page, with
+// raw_json so texts and URLs come without HTML escaping.
+func UseReddit(u string) (*MetaData, error) {
+	pu, err := url.Parse(u)
+	if err != nil {
+		return nil, err
+	}
+
+	var id string
+	if p := rxRedditPath.FindStringSubmatch(pu.Path); p != nil {
+		id = p[1]
+	} else if p := rxRedditShort.FindStringSubmatch(pu.Path); p != nil {
+		id = p[1]
+	} else {
+		return UseDefault(u)
+	}
+
+	var listings []struct {
+		Data struct {
+			Children []struct {
+				Data struct {
+					Title       string  `json:"title"`
+					Subreddit   string  `json:"subreddit"`
+					Author      string  `json:"author"`
+					Score       int     `json:"score"`
+					NumComments int     `json:"num_comments"`
+					URL         string  `json:"url"`
+					IsSelf      bool    `json:"is_self"`
+					Selftext    string  `json:"selftext"`
+					Permalink   string  `json:"permalink"`
+					Created     float64 `json:"created_utc"`
+					Thumbnail   string  `json:"thumbnail"`
+					Preview     struct {
+						Images []struct {
+							Source struct {
+								URL string `json:"url"`
+							} `json:"source"`
+						} `json:"images"`
+					} `json:"preview"`
+				} `json:"data"`
+			} `json:"children"`
+		} `json:"data"`
+	}
+	if err := getJSON("https://www.reddit.com/comments/"+id+"/.json?limit=1&raw_json=1", &listings); err != nil {
+		return UseDefault(u)
+	}
+	if len(listings) == 0 || len(listings[0].Data.Children) == 0 {
+		return UseDefault(u)
+	}
+	p := listings[0].Data.Children[0].Data
+
+	permalink := "https://www.reddit.com" + p.Permalink
+	target := ""
+	if !p.IsSelf {
+		target = discussionTarget(p.URL, permalink)
+	}
+
+	image := ""
+	if len(p.Preview.Images) > 0 {
+		image = p.Preview.Images[0].Source.URL
+	} else if strings.HasPrefix(p.Thumbnail, "http") {
+		image = p.Thumbnail
+	}
+
+	return &MetaData{
+		Title:       p.Title,
+		Description: truncate(normalizeSpace(p.Selftext), 300),
+		Image:       image,
+		Favicon:     "https://www.reddit.com/favicon.ico",
+		URL:         u,
+		Author:      p.Author,
+		Date:        time.Unix(int64(p.Created), 0).UTC().Format(time.RFC3339),
+		SiteName:    "Reddit",
+		Type:        "page",
+		Discussion: &Discussion{
+			Site:      "reddit",
+			Community: "r/" + p.Subreddit,
+			Author:    p.Author,
+			Score:     p.Score,
+			Comments:  p.NumComments,
+			Target:    target,
+		},
+	}, nil
+}
+
+// UseHackerNews reads a Hacker News item from the official Firebase API.
+func UseHackerNews(u string) (*MetaData, error) {
+	pu, err := url.Parse(u)
+	if err != nil {
+		return nil, err
+	}
+	id := pu.Query().Get("id")
+	if id == "" || strings.Trim(id, "0123456789") != "" {
+		return UseDefault(u)
+	}
+
+	var item struct {
+		Type        string `json:"type"`
+		By          string `json:"by"`
+		Title       string `json:"title"`
+		Text        string `json:"text"`
+		URL         string `json:"url"`
+		Score       int    `json:"score"`
+		Descendants int    `json:"descendants"`
+		Time        int64  `json:"time"`
+	}
+	if err := getJSON("https://hacker-news.firebaseio.com/v0/item/"+id+".json", &item); err != nil || item.Type == "" {
+		return UseDefault(u)
+	}
+
+	title := item.Title
+	if title == "" {
+		title = "Comment by " + item.By
+	}
+
+	return &MetaData{
+		Title:       title,
+		Description: truncate(htmlText(item.Text), 300),
+		Favicon:     "https://news.ycombinator.com/favicon.ico",
+		URL:         u,
+		Author:      item.By,
+		Date:        time.Unix(item.Time, 0).UTC().Format(time.RFC3339),
+		SiteName:    "Hacker News",
+		Type:        "page",
+		Discussion: &Discussion{
+			Site:     "hackernews",
+			Author:   item.By,
+			Score:    item.Score,
+			Comments: item.Descendants,
+			Target:   discussionTarget(item.URL, u),
+		},
+	}, nil
+}
+
+// UseLobsters reads a Lobsters story through the .json variant of its page.
+func UseLobsters(u string) (*MetaData, error) {
+	pu, err := url.Parse(u)
+	if err != nil {
+		return nil, err
+	}
+	p := rxLobstersPath.FindStringSubmatch(pu.Path)
+	if p == nil {
+		return UseDefault(u)
+	}
+
+	var story struct {
+		Title        string          `json:"title"`
+		URL          string          `json:"url"`
+		Score        int             `json:"score"`
+		CommentCount int             `json:"comment_count"`
+		Description  string          `json:"description"`
+		CreatedAt    string          `json:"created_at"`
+		Tags         []string        `json:"tags"`
+		Submitter    json.RawMessage `json:"submitter_user"`
+	}
+	if err := getJSON("https://lobste.rs/s/"+p[1]+".json", &story); err != nil || story.Title == "" {
+		return UseDefault(u)
+	}
+
+	// a plain user name, or an object in older versions of the API
+	var author string
+	if json.Unmarshal(story.Submitter, &author) != nil {
+		var user struct {
+			Username string `json:"username"`
+		}
+		_ = json.Unmarshal(story.Submitter, &user)
+		author = user.Username
+	}
+
+	return &MetaData{
+		Title:       story.Title,
+		Description: truncate(htmlText(story.Description), 300),
+		Favicon:     "https://lobste.rs/favicon.ico",
+		URL:         u,
+		Author:      author,
+		Date:        story.CreatedAt,
+		SiteName:    "Lobsters",
+		Type:        "page",
+		Tags:        story.Tags,
+		Discussion: &Discussion{
+			Site:     "lobsters",
+			Author:   author,
+			Score:    story.Score,
+			Comments: story.CommentCount,
+			Target:   discussionTarget(story.URL, u),
+		},
+	}, nil
+}
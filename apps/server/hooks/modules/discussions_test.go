@@ -0,0 +1,85 @@
+package modules
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestUseReddit(t *testing.T) {
+	useFixtures(t, fixtures{"www.reddit.com/comments/abc123/.json": "reddit_post.json"})
+
+	for _, u := range []string{"https://www.reddit.com/r/golang/comments/abc123/go_122_is_released/", "https://redd.it/abc123"} {
+		m, err := UseReddit(u)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if m.Title != "Go 1.22 is released" || m.Author != "gopher" || m.Date != "2024-02-06T16:00:00Z" {
+			t.Errorf("%s: got title %q, author %q, date %q", u, m.Title, m.Author, m.Date)
+		}
+		if m.Image != "https://external-preview.redd.it/go122.png?width=1200&format=png&s=abc" {
+			t.Errorf("%s: got image %q", u, m.Image)
+		}
+		want := &Discussion{Site: "reddit", Community: "r/golang", Author: "gopher", Score: 412, Comments: 57, Target: "https://go.dev/blog/go1.22"}
+		if !reflect.DeepEqual(m.Discussion, want) {
+			t.Errorf("%s: got discussion %+v, want %+v", u, m.Discussion, want)
+		}
+	}
+}
+
+func TestUseRedditSelf(t *testing.T) {
+	useFixtures(t, fixtures{"www.reddit.com/comments/def456/.json": "reddit_self.json"})
+
+	m, err := UseReddit("https://old.reddit.com/r/golang/comments/def456/project_layout/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Description != "How do you structure large projects?" || m.Image != "" {
+		t.Errorf("got description %q, image %q", m.Description, m.Image)
+	}
+	if m.Discussion == nil || m.Discussion.Target != "" {
+		t.Errorf("text post discusses %+v", m.Discussion)
+	}
+}
+
+func TestUseHackerNews(t *testing.T) {
+	useFixtures(t, fixtures{
+		"hacker-news.firebaseio.com/v0/item/39000000.json": "hn_item.json",
+		"hacker-news.firebaseio.com/v0/item/39000001.json": "hn_comment.json",
+	})
+
+	m, err := UseHackerNews("https://news.ycombinator.com/item?id=39000000")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Title != "Show HN: A bookmark manager that keeps your links" || m.SiteName != "Hacker News" || m.Date != "2024-01-11T19:06:40Z" {
+		t.Errorf("got title %q, site %q, date %q", m.Title, m.SiteName, m.Date)
+	}
+	want := &Discussion{Site: "hackernews", Author: "dang", Score: 530, Comments: 125, Target: "https://dotpen.co/"}
+	if !reflect.DeepEqual(m.Discussion, want) {
+		t.Errorf("got discussion %+v, want %+v", m.Discussion, want)
+	}
+
+	m, err = UseHackerNews("https://news.ycombinator.com/item?id=39000001")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Title != "Comment by pg" || m.Description != "This is really neat. Does it archive pages?" {
+		t.Errorf("got title %q, description %q", m.Title, m.Description)
+	}
+}
+
+func TestUseLobsters(t *testing.T) {
+	useFixtures(t, fixtures{"lobste.rs/s/abc123.json": "lobsters_story.json"})
+
+	m, err := UseLobsters("https://lobste.rs/s/abc123/writing_crawler_go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Title != "Writing a crawler in Go" || m.Author != "alice" || !reflect.DeepEqual(m.Tags, []string{"go", "web"}) {
+		t.Errorf("got title %q, author %q, tags %v", m.Title, m.Author, m.Tags)
+	}
+	want := &Discussion{Site: "lobsters", Author: "alice", Score: 42, Comments: 9, Target: "https://example.com/crawler"}
+	if !reflect.DeepEqual(m.Discussion, want) {
+		t.Errorf("got discussion %+v, want %+v", m.Discussion, want)
+	}
+}
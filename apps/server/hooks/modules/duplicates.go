@@ -0,0 +1,138 @@
+package modules
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"dotpen.co/server/hooks/lib"
+	validation "github.com/go-ozzo/ozzo-validation/v4"
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+)
+
+type DuplicateGroup struct {
+	Canonical string         `json:"canonical"`
+	Bookmarks []*core.Record `json:"bookmarks"`
+}
+
+// BookmarkOwner returns the id of the user owning the bookmark's collection.
+func BookmarkOwner(app core.App, bookmark *core.Record) (string, error) {
+	col, err := app.FindRecordById("collections", bookmark.GetString("collection"))
+	if err != nil {
+		return "", err
+	}
+	return col.GetString("user"), nil
+}
+
+// CheckDuplicate stores the canonical link on a new bookmark, unless the
+// crawled page declared one, and compares it with the owner's other,
+// non-deleted bookmarks. Depending on the owner's duplicate_policy the save
+// is rejected, or marked with duplicate_of.
+func CheckDuplicate(app core.App, bookmark *core.Record) error {
+	cu := bookmark.GetString("canonical")
+	if cu == "" {
+		var err error
+		if cu, err = lib.Canonicalize(bookmark.GetString("link")); err != nil {
+			return nil
+		}
+		bookmark.Set("canonical", cu)
+	}
+
+	owner, err := BookmarkOwner(app, bookmark)
+	if err != nil {
+		return nil
+	}
+
+	dupes, err := app.FindRecordsByFilter(
+		"bookmarks",
+		"canonical = {:canonical} && deleted = false && collection.user = {:user} && id != {:id}",
+		"created",
+		1,
+		0,
+		dbx.Params{"canonical": cu, "user": owner, "id": bookmark.Id},
+	)
+	if err != nil || len(dupes) == 0 {
+		return nil
+	}
+
+	app.Logger().Debug("RecordCreate: bookmarks", "action", "duplicate", "duplicate_of", dupes[0].Id)
+
+	if user, err := app.FindRecordById("users", owner); err == nil && user.GetString("duplicate_policy") == "reject" {
+		return validation.Errors{
+			"link": validation.NewError("validation_duplicate_link", "This link is already bookmarked").
+				SetParams(map[string]any{"duplicate_of": dupes[0].Id}),
+		}
+	}
+
+	bookmark.Set("duplicate_of", dupes[0].Id)
+	return nil
+}
+
+// CheckDuplicateOf rejects a duplicate_of set by a client unless it points
+// at another bookmark of the same owner. The server sets it in CheckDuplicate.
+func CheckDuplicateOf(app core.App, bookmark *core.Record) error {
+	id := bookmark.GetString("duplicate_of")
+	if id == "" || id == bookmark.Original().GetString("duplicate_of") {
+		return nil
+	}
+
+	invalid := validation.Errors{
+		"duplicate_of": validation.NewError("validation_invalid_duplicate_of", "Must be another of your bookmarks"),
+	}
+	if id == bookmark.Id {
+		return invalid
+	}
+	other, err := app.FindRecordById("bookmarks", id)
+	if err != nil {
+		return invalid
+	}
+	owner, err := BookmarkOwner(app, bookmark)
+	if err != nil {
+		return invalid
+	}
+	if otherOwner, err := BookmarkOwner(app, other); err != nil || otherOwner != owner {
+		return invalid
+	}
+	return nil
+}
+
+// UseDuplicates lists the groups of bookmarks of user that share a canonical
+// link, oldest bookmark first.
+func UseDuplicates(w http.ResponseWriter, r *http.Request, app core.App, user *core.Record) {
+	w.Header().Set("Content-Type", "application/json")
+
+	records, err := app.FindRecordsByFilter(
+		"bookmarks",
+		"collection.user = {:user} && deleted = false && canonical != ''",
+		"canonical,created",
+		0,
+		0,
+		dbx.Params{"user": user.Id},
+	)
+	if err != nil {
+		app.Logger().Warn("GET /api/bookmarks/duplicates: Query", "error", err.Error())
+		http.Error(w, `{"error":"failed"}`, 500)
+		return
+	}
+
+	groups := []*DuplicateGroup{}
+	var g *DuplicateGroup
+	for _, rec := range records {
+		cu := rec.GetString("canonical")
+		if g == nil || g.Canonical != cu {
+			g = &DuplicateGroup{Canonical: cu}
+			groups = append(groups, g)
+		}
+		g.Bookmarks = append(g.Bookmarks, rec)
+	}
+
+	dupes := []*DuplicateGroup{}
+	for _, g := range groups {
+		if len(g.Bookmarks) > 1 {
+			dupes = append(dupes, g)
+		}
+	}
+
+	app.Logger().Debug("GET /api/bookmarks/duplicates", "user", user.Id, "groups", len(dupes))
+	_ = json.NewEncoder(w).Encode(dupes)
+}
@@ -0,0 +1,328 @@
+package modules
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"dotpen.co/server/hooks/lib"
+	"github.com/PuerkitoBio/goquery"
+	"github.com/gabriel-vasile/mimetype"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tools/filesystem"
+	"github.com/pocketbase/pocketbase/tools/types"
+)
+
+// Icon is a favicon candidate declared by a page or its web app manifest.
+type Icon struct {
+	URL   string `json:"url"`
+	Rel   string `json:"rel,omitempty"`
+	Sizes string `json:"sizes,omitempty"`
+	Type  string `json:"type,omitempty"`
+}
+
+// ErrNoFavicon is returned by ResolveFavicon for hosts without a usable icon.
+var ErrNoFavicon = errors.New("no favicon")
+
+const (
+	// maxIconSize is the largest icon file downloaded
+	maxIconSize = 1 << 20
+	// maxIconCandidates caps the icons downloaded per host
+	maxIconCandidates = 8
+	// idealIconWidth is the width above which larger icons score no better
+	idealIconWidth = 256
+)
+
+// FaviconTTL is how long a resolved favicon is shared before the host is
+// asked again, configured through FAVICON_TTL (default 168h).
+func FaviconTTL() time.Duration {
+	return lib.EnvDuration("FAVICON_TTL", 7*24*time.Hour)
+}
+
+// faviconRetry is how long a lookup that failed on network or server errors
+// is remembered, configured through FAVICON_RETRY (default 1h).
+func faviconRetry() time.Duration {
+	return lib.EnvDuration("FAVICON_RETRY", time.Hour)
+}
+
+// pageIcons lists the icons a page declares through <link rel="icon">,
+// apple-touch-icon and friends, with their URLs resolved against base, and
+// the URL of its web app manifest.
+func pageIcons(doc *goquery.Document, base string) ([]Icon, string) {
+	var icons []Icon
+	manifest := ""
+	doc.Find("link[rel][href]").Each(func(_ int, s *goquery.Selection) {
+		rel := strings.ToLower(s.AttrOr("rel", ""))
+		href := strings.TrimSpace(s.AttrOr("href", ""))
+		if href == "" || strings.HasPrefix(href, "data:") {
+			return
+		}
+		for _, r := range strings.Fields(rel) {
+			switch r {
+			case "icon", "apple-touch-icon", "apple-touch-icon-precomposed":
+				icons = append(icons, Icon{
+					URL:   ResolveURL(base, href),
+					Rel:   r,
+					Sizes: strings.ToLower(s.AttrOr("sizes", "")),
+					Type:  strings.ToLower(s.AttrOr("type", "")),
+				})
+				return
+			case "manifest":
+				if manifest == "" {
+					manifest = ResolveURL(base, href)
+				}
+				return
+			}
+		}
+	})
+	return icons, manifest
+}
+
+// declaredWidth is the largest width in an icon's sizes attribute, -1 for
+// "any" (scalable) and 0 when nothing is declared.
+func declaredWidth(ic Icon) int {
+	best := 0
+	for _, s := range strings.Fields(ic.Sizes) {
+		if s == "any" {
+			return -1
+		}
+		w, _, _ := strings.Cut(s, "x")
+		if n, err := strconv.Atoi(w); err == nil && n > best {
+			best = n
+		}
+	}
+	if best == 0 && ic.Type == "image/svg+xml" {
+		return -1
+	}
+	if best == 0 && strings.HasPrefix(ic.Rel, "apple-touch-icon") {
+		// Apple's default size
+		return 180
+	}
+	return best
+}
+
+// iconScore ranks an icon by width and format: scalable icons win, then
+// larger ones up to idealIconWidth, with PNGs preferred over the rest.
+func iconScore(width int, format string) int {
+	switch {
+	case width < 0:
+		return 10000
+	case width > idealIconWidth:
+		width = idealIconWidth
+	}
+	score := width * 10
+	if format == "image/png" {
+		score += 5
+	}
+	return score
+}
+
+// bestDeclaredIcon is the icon a page would be shown with before any of them
+// is downloaded.
+func bestDeclaredIcon(icons []Icon) string {
+	best, bestScore := "", -1
+	for _, ic := range icons {
+		if s := iconScore(declaredWidth(ic), ic.Type); s > bestScore {
+			best, bestScore = ic.URL, s
+		}
+	}
+	return best
+}
+
+// ResolveFavicon returns the best favicon of the site m was crawled from.
+// Every candidate (the page's icons, its manifest's icons and /favicon.ico)
+// is downloaded and ranked by real size and format, with ICOs converted to
+// PNG. The result is kept per host in the favicons collection for
+// FaviconTTL, so bookmarks of one site share a single lookup. Hosts found
+// without an icon only because they couldn't be reached are asked again
+// after faviconRetry, and keep the icon they had meanwhile.
+func ResolveFavicon(app core.App, m *MetaData) (*filesystem.File, error) {
+	pu, err := url.Parse(m.URL)
+	if err != nil || pu.Host == "" {
+		return nil, fmt.Errorf("invalid url")
+	}
+	host := strings.TrimPrefix(strings.ToLower(pu.Hostname()), "www.")
+
+	rec, err := app.FindFirstRecordByData("favicons", "host", host)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return nil, err
+	}
+	if rec != nil && rec.GetDateTime("fetched").Time().Add(FaviconTTL()).After(time.Now()) {
+		return storedFavicon(app, rec)
+	}
+
+	b, source, width, unreachable := findFavicon(app, pu, m)
+	fetched := types.NowDateTime()
+	if b == nil && unreachable {
+		if rec != nil && rec.GetString("icon") != "" {
+			// the stored icon stays, but only until the next retry
+			rec.Set("fetched", fetched.Add(faviconRetry()-FaviconTTL()))
+			if err := app.Save(rec); err != nil {
+				app.Logger().Warn("Favicon: Store", "host", host, "error", err.Error())
+			}
+			return storedFavicon(app, rec)
+		}
+		fetched = fetched.Add(faviconRetry() - FaviconTTL())
+	}
+
+	if rec == nil {
+		collection, err := app.FindCachedCollectionByNameOrId("favicons")
+		if err != nil {
+			return nil, err
+		}
+		rec = core.NewRecord(collection)
+		rec.Set("host", host)
+	}
+	rec.Set("source", source)
+	rec.Set("width", width)
+	rec.Set("fetched", fetched)
+
+	var f *filesystem.File
+	if b != nil {
+		if f, err = iconFile(b); err != nil {
+			return nil, err
+		}
+		rec.Set("icon", f)
+	} else {
+		// remembered as well, so sites without one aren't asked every time
+		rec.Set("icon", nil)
+	}
+	if err := app.Save(rec); err != nil {
+		app.Logger().Warn("Favicon: Store", "host", host, "error", err.Error())
+	}
+
+	if f == nil {
+		return nil, ErrNoFavicon
+	}
+	return f, nil
+}
+
+func iconFile(b []byte) (*filesystem.File, error) {
+	return filesystem.NewFileFromBytes(b, "favicon"+mimetype.Detect(b).Extension())
+}
+
+func storedFavicon(app core.App, rec *core.Record) (*filesystem.File, error) {
+	b, err := storedFile(app, rec, "icon")
+	if err != nil {
+		return nil, err
+	}
+	if b == nil {
+		return nil, ErrNoFavicon
+	}
+	return iconFile(b)
+}
+
+// findFavicon downloads the favicon candidates of a site and returns the
+// best one, its URL and width, and whether any candidate was unreachable.
+func findFavicon(app core.App, pu *url.URL, m *MetaData) ([]byte, string, int, bool) {
+	icons := append([]Icon{}, m.Icons...)
+	if m.Manifest != "" {
+		icons = append(icons, manifestIcons(m.Manifest)...)
+	}
+
+	fallback := pu.Scheme + "://" + pu.Host + "/favicon.ico"
+	seen := map[string]bool{fallback: true}
+	candidates := []Icon{}
+	for _, ic := range icons {
+		if !seen[ic.URL] {
+			seen[ic.URL] = true
+			candidates = append(candidates, ic)
+		}
+	}
+	// only the most promising ones, in case there are many
+	if len(candidates) >= maxIconCandidates {
+		slices.SortStableFunc(candidates, func(a, b Icon) int {
+			return iconScore(declaredWidth(b), b.Type) - iconScore(declaredWidth(a), a.Type)
+		})
+		candidates = candidates[:maxIconCandidates-1]
+	}
+	candidates = append(candidates, Icon{URL: fallback, Rel: "icon"})
+
+	var best []byte
+	source, bestWidth, bestScore := "", 0, -1
+	unreachable := false
+	for _, ic := range candidates {
+		b, width, format, err := fetchIcon(ic.URL)
+		if err != nil {
+			app.Logger().Debug("Favicon: Candidate", "url", ic.URL, "error", err.Error())
+			unreachable = unreachable || errors.Is(err, errUnreachable)
+			continue
+		}
+		if s := iconScore(width, format); s > bestScore {
+			best, source, bestWidth, bestScore = b, ic.URL, width, s
+		}
+	}
+	return best, source, bestWidth, unreachable
+}
+
+// fetchIcon downloads an icon and returns it with its width (-1 for SVG)
+// and format. ICOs come back converted to PNG.
+func fetchIcon(u string) ([]byte, int, string, error) {
+	b, err := fetchAsset(u, "image/svg+xml,image/png,image/x-icon,image/*;q=0.8", maxIconSize)
+	if err != nil {
+		return nil, 0, "", err
+	}
+
+	mt := mimetype.Detect(b)
+	switch {
+	case mt.Is("image/svg+xml"):
+		return b, -1, "image/svg+xml", nil
+	case mt.Is("image/vnd.microsoft.icon"), mt.Is("image/x-icon"):
+		png, width, err := lib.ICOToPNG(b)
+		if err != nil {
+			return nil, 0, "", err
+		}
+		return png, width, "image/png", nil
+	case strings.HasPrefix(mt.String(), "image/"):
+		cfg, _, err := image.DecodeConfig(bytes.NewReader(b))
+		if err != nil {
+			return nil, 0, "", err
+		}
+		return b, cfg.Width, mt.String(), nil
+	}
+	return nil, 0, "", fmt.Errorf("not an image: %s", mt.String())
+}
+
+// manifestIcons reads the icons of a web app manifest. Maskable icons are
+// skipped, as they're padded for the OS to crop.
+func manifestIcons(u string) []Icon {
+	b, err := fetchAsset(u, "application/manifest+json,application/json", maxIconSize)
+	if err != nil {
+		return nil
+	}
+
+	var mf struct {
+		Icons []struct {
+			Src     string `json:"src"`
+			Sizes   string `json:"sizes"`
+			Type    string `json:"type"`
+			Purpose string `json:"purpose"`
+		} `json:"icons"`
+	}
+	if err := json.Unmarshal(b, &mf); err != nil {
+		return nil
+	}
+
+	var icons []Icon
+	for _, ic := range mf.Icons {
+		purpose := strings.Fields(ic.Purpose)
+		if ic.Src == "" || (len(purpose) > 0 && !strings.Contains(ic.Purpose, "any")) {
+			continue
+		}
+		icons = append(icons, Icon{
+			URL:   ResolveURL(u, ic.Src),
+			Rel:   "manifest",
+			Sizes: strings.ToLower(ic.Sizes),
+			Type:  strings.ToLower(ic.Type),
+		})
+	}
+	return icons
+}
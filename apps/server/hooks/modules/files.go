@@ -0,0 +1,165 @@
+package modules
+
+import (
+	"bufio"
+	"fmt"
+	"html"
+	"image"
+	"io"
+	"math"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+
+	_ "golang.org/x/image/bmp"
+	_ "golang.org/x/image/tiff"
+	_ "golang.org/x/image/webp"
+
+	"dotpen.co/server/hooks/lib"
+	"github.com/gabriel-vasile/mimetype"
+)
+
+// mediaHead is how much of an audio or video file is probed. The headers of
+// every supported container fit, except MP4s with their index at the end,
+// which is fetched separately up to mediaTail.
+const (
+	mediaHead = 256 << 10
+	mediaTail = 8 << 20
+)
+
+// maxFileSize is how much of a PDF is downloaded, configured through
+// CRAWL_MAX_FILE in bytes (default 25MB). Larger documents only get a title.
+func maxFileSize() int64 {
+	return int64(envInt("CRAWL_MAX_FILE", 25<<20))
+}
+
+// useFile builds the MetaData of a link to something other than a page, with
+// r positioned at the start of its body.
+func useFile(u string, r *http.Response) (*MetaData, error) {
+	br := bufio.NewReaderSize(r.Body, 3072)
+	head, _ := br.Peek(3072)
+
+	mt, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mt == "" || mt == "application/octet-stream" || mt == "binary/octet-stream" {
+		mt, _, _ = mime.ParseMediaType(mimetype.Detect(head).String())
+	}
+
+	m := &MetaData{
+		Title:        fileTitle(u),
+		Type:         "file",
+		Status:       r.StatusCode,
+		ETag:         r.Header.Get("ETag"),
+		LastModified: r.Header.Get("Last-Modified"),
+	}
+
+	switch {
+	case mt == "application/pdf":
+		usePDF(m, br)
+	case strings.HasPrefix(mt, "image/"):
+		m.Type = "image"
+		m.Image = u
+		if cfg, _, err := image.DecodeConfig(br); err == nil {
+			m.Width, m.Height = cfg.Width, cfg.Height
+		}
+	case strings.HasPrefix(mt, "audio/"), strings.HasPrefix(mt, "video/"), mt == "application/ogg":
+		m.Type = "audio"
+		if strings.HasPrefix(mt, "video/") {
+			m.Type = "video"
+		}
+		useMedia(m, u, br, r.ContentLength)
+	}
+	return m, nil
+}
+
+func usePDF(m *MetaData, r io.Reader) {
+	m.Type = "pdf"
+
+	limit := maxFileSize()
+	b, err := io.ReadAll(io.LimitReader(r, limit+1))
+	if err != nil || int64(len(b)) > limit {
+		return
+	}
+
+	info, err := lib.ReadPDF(b)
+	if err != nil {
+		return
+	}
+	if info.Title != "" {
+		m.Title = info.Title
+	}
+	m.Author = info.Author
+	m.Description = info.Subject
+	m.Pages = info.Pages
+
+	if info.FirstPage != "" {
+		var h strings.Builder
+		for _, p := range rxLines.Split(info.FirstPage, -1) {
+			if p = normalizeSpace(p); p != "" {
+				h.WriteString("<p>" + html.EscapeString(p) + "</p>")
+			}
+		}
+		m.Article = &Article{
+			HTML:  h.String(),
+			Text:  info.FirstPage,
+			Words: len(strings.Fields(info.FirstPage)),
+		}
+	}
+}
+
+func useMedia(m *MetaData, u string, r io.Reader, size int64) {
+	head, _ := io.ReadAll(io.LimitReader(r, mediaHead))
+
+	info, err := lib.ProbeMedia(head, size, func(offset int64) ([]byte, error) {
+		return fetchRange(u, offset)
+	})
+	if err != nil {
+		return
+	}
+
+	m.Duration = info.Duration
+	m.ReadingTime = int(math.Ceil(info.Duration / 60))
+	m.Width, m.Height = info.Width, info.Height
+	m.Codec = strings.Join(info.Codecs, ", ")
+	if len(info.Codecs) > 0 {
+		m.Type = "audio"
+		if info.Video {
+			m.Type = "video"
+		}
+	}
+}
+
+// fetchRange reads u from offset on, up to mediaTail, for servers supporting
+// range requests.
+func fetchRange(u string, offset int64) ([]byte, error) {
+	req, _ := http.NewRequest("GET", u, nil)
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+
+	r, err := lib.UseProxy(req)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Body.Close()
+
+	if r.StatusCode != http.StatusPartialContent {
+		return nil, fmt.Errorf("HTTP %d", r.StatusCode)
+	}
+	return io.ReadAll(io.LimitReader(r.Body, mediaTail))
+}
+
+// fileTitle is the file name at the end of u, or its host for bare domains.
+func fileTitle(u string) string {
+	pu, err := url.Parse(u)
+	if err != nil {
+		return u
+	}
+	if name := path.Base(pu.Path); name != "/" && name != "." {
+		return name
+	}
+	return pu.Hostname()
+}
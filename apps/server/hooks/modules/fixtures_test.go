@@ -0,0 +1,63 @@
+package modules
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"dotpen.co/server/hooks/lib"
+)
+
+// fixtures answers requests from files in testdata, by host and path, in
+// place of the configured transports. Anything else is a 404.
+type fixtures map[string]string
+
+func (f fixtures) Name() string { return "fixtures" }
+
+func (f fixtures) Do(req *http.Request) (*http.Response, error) {
+	resp := &http.Response{
+		StatusCode: http.StatusNotFound,
+		Header:     http.Header{},
+		Body:       io.NopCloser(bytes.NewReader(nil)),
+		Request:    req,
+	}
+	name, ok := f[req.URL.Host+req.URL.Path]
+	if !ok {
+		return resp, nil
+	}
+
+	b, err := os.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		return nil, err
+	}
+	resp.StatusCode = http.StatusOK
+	resp.Header.Set("Content-Type", "application/json")
+	resp.Body = io.NopCloser(bytes.NewReader(b))
+	return resp, nil
+}
+
+// useFixtures points the crawlers at the given fixtures for one test.
+func useFixtures(t *testing.T, f fixtures) {
+	t.Helper()
+	lib.SetTransports(f)
+	t.Cleanup(func() { lib.SetTransports(fixtures{}) })
+}
+
+func TestMain(m *testing.M) {
+	os.Setenv("FETCH_HOST_RATE", "1000")
+	os.Setenv("FETCH_HOST_BURST", "1000")
+	os.Unsetenv("GITHUB_TOKEN")
+
+	// every host is public and nothing leaves the machine
+	lib.SetResolver(func(context.Context, string) ([]net.IPAddr, error) {
+		return []net.IPAddr{{IP: net.IPv4(93, 184, 216, 34)}}, nil
+	})
+	lib.SetTransports(fixtures{})
+
+	os.Exit(m.Run())
+}
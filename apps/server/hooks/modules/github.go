@@ -0,0 +1,229 @@
+package modules
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+
+	"dotpen.co/server/hooks/lib"
+)
+
+// Repository is what site specific crawlers know about a code repository.
+type Repository struct {
+	Name     string   `json:"name"`
+	Language string   `json:"language,omitempty"`
+	Stars    int      `json:"stars"`
+	Forks    int      `json:"forks"`
+	Topics   []string `json:"topics,omitempty"`
+	Archived bool     `json:"archived,omitempty"`
+	PushedAt string   `json:"pushed_at,omitempty"`
+}
+
+// Issue is what site specific crawlers know about an issue or pull request.
+type Issue struct {
+	Number int  `json:"number"`
+	Pull   bool `json:"pull,omitempty"`
+	// State is open or closed, and merged or draft for pull requests
+	State    string   `json:"state"`
+	Author   string   `json:"author,omitempty"`
+	Labels   []string `json:"labels,omitempty"`
+	Comments int      `json:"comments"`
+}
+
+var rxGitHubPath = regexp.MustCompile(`^/([^/]+)/([^/]+?)(?:\.git)?(?:/(issues|pull)/(\d+))?(?:/.*)?$`)
+
+// gitHubReserved are first path segments that aren't users or organizations.
+var gitHubReserved = map[string]bool{
+	"about": true, "apps": true, "collections": true, "customer-stories": true, "enterprise": true,
+	"events": true, "explore": true, "features": true, "issues": true, "login": true, "marketplace": true,
+	"new": true, "notifications": true, "orgs": true, "organizations": true, "pricing": true, "pulls": true,
+	"search": true, "security": true, "settings": true, "site": true, "sponsors": true, "topics": true, "trending": true,
+}
+
+// errGitHubPrivate drops what GITHUB_TOKEN could read of a private repository.
+var errGitHubPrivate = errors.New("GitHub API: private repository")
+
+func init() {
+	RegisterCrawler(&HostCrawler{Key: "github", Hosts: []string{"github.com"}, Path: rxGitHubPath, Use: UseGitHub})
+}
+
+// UseGitHub reads repositories, issues and pull requests from the GitHub
+// REST API. GITHUB_TOKEN, when set, only raises the rate limit: it is sent
+// directly to GitHub, results of private repositories are dropped and
+// results fetched with it aren't cached. Anything else, and API failures, go
+// to UseDefault.
+func UseGitHub(u string) (*MetaData, error) {
+	pu, err := url.Parse(u)
+	if err != nil {
+		return nil, err
+	}
+
+	p := rxGitHubPath.FindStringSubmatch(pu.Path)
+	if p == nil || gitHubReserved[strings.ToLower(p[1])] {
+		return UseDefault(u)
+	}
+	owner, repo, kind, number := p[1], p[2], p[3], p[4]
+
+	var m *MetaData
+	if kind != "" {
+		m, err = gitHubIssue(owner, repo, kind == "pull", number)
+	} else if strings.Trim(strings.TrimPrefix(pu.Path, "/"+owner+"/"+repo), "/") == "" {
+		m, err = gitHubRepo(owner, repo)
+	} else {
+		// files, releases, wikis and the like keep the page's own card
+		return UseDefault(u)
+	}
+	if err != nil {
+		return UseDefault(u)
+	}
+
+	m.URL = u
+	m.SiteName = "GitHub"
+	m.Type = "page"
+	m.Favicon = "https://github.com/favicon.ico"
+	m.NoCache = os.Getenv("GITHUB_TOKEN") != ""
+	return m, nil
+}
+
+func gitHubAPI(path string, v any) error {
+	req, err := http.NewRequest("GET", "https://api.github.com"+path, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/vnd.github+json")
+	req.Header.Set("X-GitHub-Api-Version", "2022-11-28")
+	req = lib.API(req)
+	if token := os.Getenv("GITHUB_TOKEN"); token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+		req = lib.Private(req)
+	}
+
+	r, err := lib.UseProxy(req)
+	if err != nil {
+		return err
+	}
+	defer r.Body.Close()
+
+	if r.StatusCode != http.StatusOK {
+		return fmt.Errorf("GitHub API: HTTP %d", r.StatusCode)
+	}
+	return json.NewDecoder(r.Body).Decode(v)
+}
+
+func gitHubRepo(owner, repo string) (*MetaData, error) {
+	var d struct {
+		FullName    string   `json:"full_name"`
+		Description string   `json:"description"`
+		Language    string   `json:"language"`
+		Stars       int      `json:"stargazers_count"`
+		Forks       int      `json:"forks_count"`
+		Topics      []string `json:"topics"`
+		Archived    bool     `json:"archived"`
+		Private     bool     `json:"private"`
+		PushedAt    string   `json:"pushed_at"`
+		CreatedAt   string   `json:"created_at"`
+		Owner       struct {
+			Login string `json:"login"`
+		} `json:"owner"`
+	}
+	if err := gitHubAPI("/repos/"+url.PathEscape(owner)+"/"+url.PathEscape(repo), &d); err != nil {
+		return nil, err
+	}
+	if d.Private {
+		return nil, errGitHubPrivate
+	}
+
+	return &MetaData{
+		Title:       d.FullName,
+		Description: d.Description,
+		Image:       "https://opengraph.githubassets.com/1/" + d.FullName,
+		Author:      d.Owner.Login,
+		Date:        d.CreatedAt,
+		Tags:        d.Topics,
+		Repo: &Repository{
+			Name:     d.FullName,
+			Language: d.Language,
+			Stars:    d.Stars,
+			Forks:    d.Forks,
+			Topics:   d.Topics,
+			Archived: d.Archived,
+			PushedAt: d.PushedAt,
+		},
+	}, nil
+}
+
+func gitHubIssue(owner, repo string, pull bool, number string) (*MetaData, error) {
+	var d struct {
+		Number   int    `json:"number"`
+		Title    string `json:"title"`
+		Body     string `json:"body"`
+		State    string `json:"state"`
+		Comments int    `json:"comments"`
+		Merged   bool   `json:"merged"`
+		Draft    bool   `json:"draft"`
+		Created  string `json:"created_at"`
+		User     struct {
+			Login string `json:"login"`
+		} `json:"user"`
+		Labels []struct {
+			Name string `json:"name"`
+		} `json:"labels"`
+	}
+	// the issues endpoint answers for pull requests too, but only pulls
+	// know whether they were merged
+	endpoint, page := "issues", "issues"
+	if pull {
+		endpoint, page = "pulls", "pull"
+	}
+	if os.Getenv("GITHUB_TOKEN") != "" {
+		// issues don't say whether their repository is private
+		if _, err := gitHubRepo(owner, repo); err != nil {
+			return nil, err
+		}
+	}
+	full := url.PathEscape(owner) + "/" + url.PathEscape(repo)
+	if err := gitHubAPI("/repos/"+full+"/"+endpoint+"/"+number, &d); err != nil {
+		return nil, err
+	}
+
+	issue := &Issue{
+		Number:   d.Number,
+		Pull:     pull,
+		State:    d.State,
+		Author:   d.User.Login,
+		Comments: d.Comments,
+	}
+	switch {
+	case d.Merged:
+		issue.State = "merged"
+	case d.Draft && d.State == "open":
+		issue.State = "draft"
+	}
+	for _, l := range d.Labels {
+		issue.Labels = append(issue.Labels, l.Name)
+	}
+
+	return &MetaData{
+		Title:       fmt.Sprintf("%s · %s/%s#%d", d.Title, owner, repo, d.Number),
+		Description: truncate(normalizeSpace(d.Body), 300),
+		Image:       fmt.Sprintf("https://opengraph.githubassets.com/1/%s/%s/%s/%d", owner, repo, page, d.Number),
+		Author:      d.User.Login,
+		Date:        d.Created,
+		Tags:        issue.Labels,
+		Issue:       issue,
+	}, nil
+}
+
+// truncate cuts s to at most n runes, ending it with an ellipsis.
+func truncate(s string, n int) string {
+	r := []rune(s)
+	if len(r) <= n {
+		return s
+	}
+	return strings.TrimSpace(string(r[:n-1])) + "…"
+}
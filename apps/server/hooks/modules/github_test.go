@@ -0,0 +1,83 @@
+package modules
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestUseGitHubRepo(t *testing.T) {
+	useFixtures(t, fixtures{"api.github.com/repos/golang/go": "github_repo.json"})
+
+	m, err := UseGitHub("https://github.com/golang/go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Title != "golang/go" || m.Description != "The Go programming language" || m.Author != "golang" {
+		t.Errorf("got title %q, description %q, author %q", m.Title, m.Description, m.Author)
+	}
+	if m.SiteName != "GitHub" || m.Image != "https://opengraph.githubassets.com/1/golang/go" {
+		t.Errorf("got site %q, image %q", m.SiteName, m.Image)
+	}
+	want := &Repository{
+		Name:     "golang/go",
+		Language: "Go",
+		Stars:    128000,
+		Forks:    18000,
+		Topics:   []string{"go", "golang", "language", "programming-language"},
+		PushedAt: "2025-06-01T09:58:02Z",
+	}
+	if !reflect.DeepEqual(m.Repo, want) {
+		t.Errorf("got repo %+v, want %+v", m.Repo, want)
+	}
+	if m.NoCache {
+		t.Error("results read without a token are cached")
+	}
+}
+
+func TestUseGitHubPull(t *testing.T) {
+	useFixtures(t, fixtures{"api.github.com/repos/golang/go/pulls/65000": "github_pull.json"})
+
+	m, err := UseGitHub("https://github.com/golang/go/pull/65000/files")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Title != "cmd/go: fix module cache locking · golang/go#65000" {
+		t.Errorf("got title %q", m.Title)
+	}
+	if m.Description != "This fixes the locking of the module cache." {
+		t.Errorf("got description %q", m.Description)
+	}
+	want := &Issue{Number: 65000, Pull: true, State: "merged", Author: "gopher", Labels: []string{"cla: yes", "GoCommand"}, Comments: 4}
+	if !reflect.DeepEqual(m.Issue, want) {
+		t.Errorf("got issue %+v, want %+v", m.Issue, want)
+	}
+}
+
+func TestUseGitHubIssue(t *testing.T) {
+	useFixtures(t, fixtures{"api.github.com/repos/golang/go/issues/64000": "github_issue.json"})
+
+	m, err := UseGitHub("https://github.com/golang/go/issues/64000")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Issue == nil || m.Issue.Pull || m.Issue.State != "open" || m.Issue.Comments != 12 {
+		t.Errorf("got issue %+v", m.Issue)
+	}
+	if m.Image != "https://opengraph.githubassets.com/1/golang/go/issues/64000" {
+		t.Errorf("got image %q", m.Image)
+	}
+}
+
+func TestUseGitHubPrivate(t *testing.T) {
+	t.Setenv("GITHUB_TOKEN", "token")
+	useFixtures(t, fixtures{
+		"api.github.com/repos/acme/secret":          "github_private.json",
+		"api.github.com/repos/acme/secret/issues/1": "github_issue.json",
+	})
+
+	for _, u := range []string{"https://github.com/acme/secret", "https://github.com/acme/secret/issues/1"} {
+		if m, err := UseGitHub(u); err == nil && (m.Repo != nil || m.Issue != nil) {
+			t.Errorf("%s: private repository read: %+v", u, m)
+		}
+	}
+}
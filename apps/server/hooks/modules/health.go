@@ -0,0 +1,210 @@
+package modules
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"dotpen.co/server/hooks/lib"
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tools/types"
+)
+
+// CheckLinks re-checks the links of bookmarks that were never checked, or not
+// within LINK_CHECK_AGE (default 168h), at most LINK_CHECK_BATCH (default 500)
+// per run. Hosts are checked in parallel, but the links of one host one after
+// another, LINK_CHECK_DELAY (default 2s) apart.
+func CheckLinks(app core.App) {
+	cutoff := types.NowDateTime().Add(-lib.EnvDuration("LINK_CHECK_AGE", 7*24*time.Hour))
+
+	records, err := app.FindRecordsByFilter(
+		"bookmarks",
+		"deleted = false && link != '' && (checked = '' || checked < {:cutoff})",
+		"checked",
+		envInt("LINK_CHECK_BATCH", 500),
+		0,
+		dbx.Params{"cutoff": cutoff.String()},
+	)
+	if err != nil {
+		app.Logger().Error("Cron: Check bookmark links", "error", err.Error())
+		return
+	}
+
+	hosts := map[string][]*core.Record{}
+	for _, r := range records {
+		host := ""
+		if pu, err := url.Parse(r.GetString("link")); err == nil {
+			host = strings.TrimPrefix(strings.ToLower(pu.Hostname()), "www.")
+		}
+		hosts[host] = append(hosts[host], r)
+	}
+
+	app.Logger().Debug("Cron: Check bookmark links", "bookmarks", len(records), "hosts", len(hosts))
+
+	delay := lib.EnvDuration("LINK_CHECK_DELAY", 2*time.Second)
+	sem := make(chan struct{}, envInt("LINK_CHECK_WORKERS", 4))
+	var wg sync.WaitGroup
+	for _, list := range hosts {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(list []*core.Record) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			for i, r := range list {
+				if i > 0 {
+					time.Sleep(delay)
+				}
+				checkBookmark(app, r)
+			}
+		}(list)
+	}
+	wg.Wait()
+}
+
+func checkBookmark(app core.App, bookmark *core.Record) {
+	link := bookmark.GetString("link")
+	st, err := lib.CheckLink(link)
+	if st == nil {
+		st = &lib.LinkStatus{Broken: true}
+	}
+	if err != nil {
+		app.Logger().Debug("Cron: Check bookmark link", "id", bookmark.Id, "url", link, "error", err.Error())
+	}
+
+	// the check may have taken a while, don't overwrite edits made meanwhile
+	fresh, err := app.FindRecordById("bookmarks", bookmark.Id)
+	if err != nil || fresh.GetString("link") != link {
+		return
+	}
+
+	// the target is stored as sent, with its query and fragment, to become
+	// the link on a rewrite; only landing elsewhere makes it a move
+	redirect := ""
+	if st.Final != "" {
+		if a, err := lib.Canonicalize(st.Final); err == nil && a != fresh.GetString("canonical") {
+			redirect = st.Final
+		}
+	}
+
+	fresh.Set("status", st.Status)
+	fresh.Set("redirect", redirect)
+	fresh.Set("permanent", redirect != "" && st.Permanent)
+	fresh.Set("broken", st.Broken)
+	fresh.Set("checked", types.NowDateTime())
+	if err := app.Save(fresh); err != nil {
+		app.Logger().Warn("Cron: Check bookmark link", "id", bookmark.Id, "error", err.Error())
+	}
+}
+
+// ResetLinkHealth clears the check results of a bookmark whose link changed.
+func ResetLinkHealth(bookmark *core.Record) {
+	if bookmark.Original().GetString("link") == bookmark.GetString("link") {
+		return
+	}
+	bookmark.Set("status", 0)
+	bookmark.Set("redirect", "")
+	bookmark.Set("permanent", false)
+	bookmark.Set("broken", false)
+	bookmark.Set("checked", "")
+}
+
+// permanentRedirect reports whether the last check of bookmark found only
+// permanent redirects (301 or 308) on the way to another location.
+func permanentRedirect(bookmark *core.Record) bool {
+	return bookmark.GetString("redirect") != "" && bookmark.GetBool("permanent")
+}
+
+type LinkHealth struct {
+	Broken []*core.Record `json:"broken"`
+	Moved  []*core.Record `json:"moved"`
+}
+
+// UseLinkHealth lists the bookmarks of user whose link is broken or
+// redirects elsewhere.
+func UseLinkHealth(w http.ResponseWriter, r *http.Request, app core.App, user *core.Record) {
+	w.Header().Set("Content-Type", "application/json")
+
+	records, err := app.FindRecordsByFilter(
+		"bookmarks",
+		"collection.user = {:user} && deleted = false && (broken = true || redirect != '')",
+		"-checked",
+		0,
+		0,
+		dbx.Params{"user": user.Id},
+	)
+	if err != nil {
+		app.Logger().Warn("GET /api/bookmarks/health: Query", "error", err.Error())
+		http.Error(w, `{"error":"failed"}`, 500)
+		return
+	}
+
+	res := &LinkHealth{Broken: []*core.Record{}, Moved: []*core.Record{}}
+	for _, rec := range records {
+		if rec.GetBool("broken") {
+			res.Broken = append(res.Broken, rec)
+		} else {
+			res.Moved = append(res.Moved, rec)
+		}
+	}
+
+	app.Logger().Debug("GET /api/bookmarks/health", "user", user.Id, "broken", len(res.Broken), "moved", len(res.Moved))
+	_ = json.NewEncoder(w).Encode(res)
+}
+
+type RewriteRequest struct {
+	IDs []string `json:"ids"`
+}
+
+// UseRewriteRedirects points the bookmarks of user that were found to
+// permanently redirect at their new location. Without ids, every such
+// bookmark is rewritten.
+func UseRewriteRedirects(w http.ResponseWriter, r *http.Request, app core.App, user *core.Record) {
+	w.Header().Set("Content-Type", "application/json")
+
+	body := &RewriteRequest{}
+	if err := json.NewDecoder(io.LimitReader(r.Body, 64<<10)).Decode(body); err != nil && err != io.EOF {
+		http.Error(w, `{"error":"Invalid body"}`, 400)
+		return
+	}
+
+	records, err := app.FindRecordsByFilter(
+		"bookmarks",
+		"collection.user = {:user} && deleted = false && redirect != '' && permanent = true",
+		"",
+		0,
+		0,
+		dbx.Params{"user": user.Id},
+	)
+	if err != nil {
+		http.Error(w, `{"error":"failed"}`, 500)
+		return
+	}
+
+	only := map[string]bool{}
+	for _, id := range body.IDs {
+		only[id] = true
+	}
+
+	rewritten := []*core.Record{}
+	for _, rec := range records {
+		if (len(only) > 0 && !only[rec.Id]) || !permanentRedirect(rec) {
+			continue
+		}
+
+		rec.Set("link", rec.GetString("redirect"))
+		if err := app.Save(rec); err != nil {
+			app.Logger().Warn("POST /api/bookmarks/health/rewrite: Save", "id", rec.Id, "error", err.Error())
+			continue
+		}
+		rewritten = append(rewritten, rec)
+	}
+
+	app.Logger().Debug("POST /api/bookmarks/health/rewrite", "user", user.Id, "rewritten", len(rewritten))
+	_ = json.NewEncoder(w).Encode(rewritten)
+}
@@ -0,0 +1,266 @@
+package modules
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"dotpen.co/server/hooks/lib"
+	"github.com/gabriel-vasile/mimetype"
+	validation "github.com/go-ozzo/ozzo-validation/v4"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tools/filesystem"
+)
+
+// maxAssetSize caps favicons and covers downloaded for a bookmark.
+const maxAssetSize = 5 << 20
+
+// errUnreachable marks fetchAsset errors worth trying again later: network
+// failures, timeouts and server errors.
+var errUnreachable = errors.New("unreachable")
+
+type IngestRequest struct {
+	URL        string `json:"url"`
+	Collection string `json:"collection"`
+	Async      bool   `json:"async"`
+}
+
+// UseIngest crawls a URL, downloads its favicon and cover and creates the
+// bookmark in one call, so clients don't have to stitch /api/crawl,
+// /api/proxy and the records API together themselves.
+//
+// With async set, the bookmark is created right away with the host name as
+// label and enriched by the crawl queue.
+func UseIngest(w http.ResponseWriter, r *http.Request, app core.App, user *core.Record) {
+	w.Header().Set("Content-Type", "application/json")
+	defer func() {
+		if rec := recover(); rec != nil {
+			app.Logger().Warn("POST /api/bookmarks/ingest: Panic", "error", fmt.Sprint(rec))
+			http.Error(w, `{"error":"Internal error"}`, 500)
+		}
+	}()
+
+	body := &IngestRequest{}
+	if err := json.NewDecoder(io.LimitReader(r.Body, 64<<10)).Decode(body); err != nil {
+		http.Error(w, `{"error":"Invalid body"}`, 400)
+		return
+	}
+	if body.URL == "" || body.Collection == "" {
+		http.Error(w, `{"error":"URL and collection required"}`, 400)
+		return
+	}
+
+	col, err := app.FindRecordById("collections", body.Collection)
+	if err != nil || col.GetString("user") != user.Id {
+		http.Error(w, `{"error":"Collection not found"}`, 404)
+		return
+	}
+
+	// kept as entered, fragment and all; only a missing scheme is added
+	entered := strings.TrimSpace(body.URL)
+	if !strings.Contains(entered, "://") {
+		entered = "https://" + entered
+	}
+
+	var m *MetaData
+	if body.Async {
+		if cu, err := lib.Canonicalize(body.URL); err == nil {
+			m = &MetaData{URL: cu}
+		}
+	} else {
+		m, _ = CrawlArticle(app, body.URL)
+	}
+	if m == nil {
+		http.Error(w, `{"error":"Invalid URL"}`, 400)
+		return
+	}
+
+	bookmarks, err := app.FindCachedCollectionByNameOrId("bookmarks")
+	if err != nil {
+		http.Error(w, `{"error":"failed"}`, 500)
+		return
+	}
+
+	record := core.NewRecord(bookmarks)
+	record.Set("collection", col.Id)
+	record.Set("link", entered)
+	ApplyMeta(record, m)
+	ApplyAssets(app, record, m)
+
+	err = app.RunInTransaction(func(txApp core.App) error {
+		return txApp.Save(record)
+	})
+	if err != nil {
+		var verrs validation.Errors
+		if errors.As(err, &verrs) {
+			w.WriteHeader(400)
+			_ = json.NewEncoder(w).Encode(map[string]any{"error": "Invalid bookmark", "data": verrs})
+			return
+		}
+		app.Logger().Warn("POST /api/bookmarks/ingest: Save", "url", m.URL, "error", err.Error())
+		http.Error(w, `{"error":"failed"}`, 500)
+		return
+	}
+
+	if body.Async {
+		if err := EnqueueCrawl(app, record); err != nil {
+			app.Logger().Warn("POST /api/bookmarks/ingest: Enqueue", "url", m.URL, "error", err.Error())
+		}
+	}
+
+	app.Logger().Debug("POST /api/bookmarks/ingest", "id", record.Id, "url", m.URL)
+	_ = json.NewEncoder(w).Encode(record)
+}
+
+// ApplyMeta copies crawled MetaData onto a bookmark record. The link is only
+// set on bookmarks without one, as users' links are kept as entered.
+func ApplyMeta(record *core.Record, m *MetaData) {
+	label := m.Title
+	if label == "" {
+		if pu, err := url.Parse(m.URL); err == nil {
+			label = pu.Hostname()
+		}
+	}
+	record.Set("label", label)
+	if record.GetString("link") == "" {
+		record.Set("link", m.URL)
+	}
+	record.Set("crawl", snapshotOf(m))
+	if m.Canonical != "" {
+		record.Set("canonical", m.Canonical)
+	}
+	applyDetails(record, m)
+
+	if m.Article != nil {
+		applyArticle(record, m.Article)
+	}
+}
+
+// rxLang matches BCP 47 language tags, like "en" or "pt-BR".
+var rxLang = regexp.MustCompile(`^[A-Za-z]{2,8}(-[A-Za-z0-9]{1,8})*$`)
+
+// applyDetails copies the MetaData fields users don't edit, which describe
+// the page rather than the bookmark, onto a bookmark record. Values are
+// clamped to the field limits, pages put anything in their meta tags.
+func applyDetails(record *core.Record, m *MetaData) {
+	record.Set("site_name", truncate(m.SiteName, 200))
+	record.Set("type", m.Type)
+	record.Set("og_type", truncate(m.OGType, 100))
+	if len(m.Lang) <= 35 && rxLang.MatchString(m.Lang) {
+		record.Set("lang", m.Lang)
+	} else {
+		record.Set("lang", "")
+	}
+	record.Set("tags", m.Tags)
+	record.Set("twitter", m.Twitter)
+	record.Set("reading_time", m.ReadingTime)
+
+	// only a discussion crawler knows; a fallback to UseDefault, say when
+	// the site's API is down, leaves the link found earlier alone
+	if m.Discussion != nil {
+		record.Set("discusses", m.Discussion.Target)
+	}
+}
+
+// ApplyAssets downloads the favicon and cover of m onto a bookmark record.
+// Missing or invalid images are skipped; the bookmark is still worth saving.
+func ApplyAssets(app core.App, record *core.Record, m *MetaData) {
+	for field, f := range fetchAssets(app, m) {
+		record.Set(field, f)
+	}
+}
+
+// fetchAssets downloads the favicon and cover of m, by field name.
+func fetchAssets(app core.App, m *MetaData) map[string]*filesystem.File {
+	assets := map[string]*filesystem.File{}
+	for field, src := range map[string]string{"favicon": m.Favicon, "cover": m.Image} {
+		if src == "" {
+			continue
+		}
+
+		f, err := fetchBookmarkAsset(app, m, field, src)
+		if err != nil {
+			app.Logger().Debug("Ingest: Asset download", "field", field, "url", src, "error", err.Error())
+			continue
+		}
+		assets[field] = f
+	}
+	return assets
+}
+
+// fetchBookmarkAsset downloads the favicon or cover src of m. Favicons of
+// crawled pages go through ResolveFavicon, the ones set by site specific
+// crawlers (such as avatars) are taken as they are.
+func fetchBookmarkAsset(app core.App, m *MetaData, field, src string) (*filesystem.File, error) {
+	if field == "favicon" && (len(m.Icons) > 0 || m.Manifest != "" || strings.HasSuffix(src, "/favicon.ico")) {
+		return ResolveFavicon(app, m)
+	}
+	return FetchImage(ResolveURL(m.URL, src), field)
+}
+
+// ResolveURL resolves ref against the page URL base.
+func ResolveURL(base, ref string) string {
+	b, err := url.Parse(base)
+	if err != nil {
+		return ref
+	}
+	r, err := url.Parse(strings.TrimSpace(ref))
+	if err != nil {
+		return ref
+	}
+	return b.ResolveReference(r).String()
+}
+
+// FetchImage downloads an image through the configured transports and checks
+// it really is one, returning it as a file named after name.
+func FetchImage(u string, name string) (*filesystem.File, error) {
+	b, err := fetchAsset(u, "image/avif,image/webp,image/png,image/svg+xml,image/*;q=0.8,*/*;q=0.5", maxAssetSize)
+	if err != nil {
+		return nil, err
+	}
+
+	mt := mimetype.Detect(b)
+	if !strings.HasPrefix(mt.String(), "image/") {
+		return nil, fmt.Errorf("not an image: %s", mt.String())
+	}
+
+	return filesystem.NewFileFromBytes(b, name+mt.Extension())
+}
+
+// fetchAsset downloads u through the configured transports, refusing bodies
+// larger than limit bytes. Failures that may pass are wrapped in
+// errUnreachable.
+func fetchAsset(u, accept string, limit int64) ([]byte, error) {
+	req, err := http.NewRequest("GET", u, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", accept)
+
+	r, err := lib.UseProxy(lib.Asset(req))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", errUnreachable, err)
+	}
+	defer r.Body.Close()
+
+	if r.StatusCode >= 500 || r.StatusCode == 408 || r.StatusCode == 429 {
+		return nil, fmt.Errorf("%w: HTTP %d", errUnreachable, r.StatusCode)
+	}
+	if r.StatusCode >= 400 {
+		return nil, fmt.Errorf("HTTP %d", r.StatusCode)
+	}
+
+	b, err := io.ReadAll(io.LimitReader(r.Body, limit+1))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", errUnreachable, err)
+	}
+	if int64(len(b)) > limit {
+		return nil, fmt.Errorf("larger than %d bytes", limit)
+	}
+	return b, nil
+}
@@ -0,0 +1,344 @@
+package modules
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"dotpen.co/server/hooks/lib"
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tools/types"
+)
+
+const (
+	jobMaxAttempts = 5
+	jobBaseBackoff = 30 * time.Second
+	jobMaxBackoff  = time.Hour
+)
+
+// CrawlQueue works through the crawl_jobs collection with a pool of workers.
+// Jobs survive restarts, are retried with exponential backoff, and at most
+// perHost jobs run against the same host at once. Results are saved onto the
+// bookmark, so clients subscribed to it through realtime see it fill in.
+type CrawlQueue struct {
+	app     core.App
+	workers int
+	perHost int
+
+	mu    sync.Mutex
+	hosts map[string]int
+	wake  chan struct{}
+}
+
+var queue *CrawlQueue
+
+func envInt(key string, def int) int {
+	if v, err := strconv.Atoi(os.Getenv(key)); err == nil && v > 0 {
+		return v
+	}
+	return def
+}
+
+// StartCrawlQueue starts the workers until ctx is done. The pool size and the
+// per-host cap are configured through CRAWL_WORKERS (default 4) and
+// CRAWL_HOST_CONCURRENCY (default 2).
+func StartCrawlQueue(ctx context.Context, app core.App) *CrawlQueue {
+	q := &CrawlQueue{
+		app:     app,
+		workers: envInt("CRAWL_WORKERS", 4),
+		perHost: envInt("CRAWL_HOST_CONCURRENCY", 2),
+		hosts:   map[string]int{},
+		wake:    make(chan struct{}, 1),
+	}
+	queue = q
+
+	for i := 0; i < q.workers; i++ {
+		go q.work(ctx)
+	}
+	go q.requeue(ctx)
+	return q
+}
+
+// requeue puts jobs back that have been running for longer than
+// CRAWL_JOB_LEASE (default 30m), now and then every lease. Those were left
+// by a process that stopped, while younger ones may still be running on
+// another instance.
+func (q *CrawlQueue) requeue(ctx context.Context) {
+	lease := lib.EnvDuration("CRAWL_JOB_LEASE", 30*time.Minute)
+	tick := time.NewTicker(lease)
+	defer tick.Stop()
+
+	for {
+		res, err := q.app.DB().NewQuery("UPDATE crawl_jobs SET status = 'pending' WHERE status = 'running' AND updated < {:cutoff}").
+			Bind(dbx.Params{"cutoff": types.NowDateTime().Add(-lease).String()}).Execute()
+		if err != nil {
+			q.app.Logger().Warn("CrawlQueue: Requeue", "error", err.Error())
+		} else if n, _ := res.RowsAffected(); n > 0 {
+			q.app.Logger().Debug("CrawlQueue: Requeue", "jobs", n)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-tick.C:
+		}
+	}
+}
+
+// EnqueueCrawl schedules the enrichment of bookmark in the background.
+func EnqueueCrawl(app core.App, bookmark *core.Record) error {
+	return enqueue(app, bookmark, "crawl")
+}
+
+// EnqueueRefresh schedules a refresh of bookmark, which fills in what is
+// missing and keeps what was already set.
+func EnqueueRefresh(app core.App, bookmark *core.Record) error {
+	return enqueue(app, bookmark, "refresh")
+}
+
+// EnqueueArchive schedules an offline copy of the bookmark's page.
+func EnqueueArchive(app core.App, bookmark *core.Record) error {
+	return enqueue(app, bookmark, "archive")
+}
+
+// EnqueueCover schedules the variants and placeholders of a newly set cover.
+func EnqueueCover(app core.App, bookmark *core.Record) error {
+	return enqueue(app, bookmark, "cover")
+}
+
+func enqueue(app core.App, bookmark *core.Record, kind string) error {
+	collection, err := app.FindCachedCollectionByNameOrId("crawl_jobs")
+	if err != nil {
+		return err
+	}
+
+	link := bookmark.GetString("link")
+	host := ""
+	if pu, err := url.Parse(link); err == nil {
+		host = strings.ToLower(pu.Hostname())
+	}
+
+	job := core.NewRecord(collection)
+	job.Set("bookmark", bookmark.Id)
+	job.Set("kind", kind)
+	job.Set("url", link)
+	job.Set("host", host)
+	job.Set("status", "pending")
+	job.Set("next_run", types.NowDateTime())
+	if err := app.Save(job); err != nil {
+		return err
+	}
+
+	if queue != nil {
+		select {
+		case queue.wake <- struct{}{}:
+		default:
+		}
+	}
+	return nil
+}
+
+func (q *CrawlQueue) work(ctx context.Context) {
+	tick := time.NewTicker(5 * time.Second)
+	defer tick.Stop()
+
+	for {
+		if job := q.claim(); job != nil {
+			q.runSafe(job)
+			q.release(job.GetString("host"))
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-q.wake:
+		case <-tick.C:
+		}
+	}
+}
+
+// claim picks the next due job whose host has a free slot and marks it
+// running. The conditional update keeps other instances from taking it too.
+func (q *CrawlQueue) claim() *core.Record {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	jobs, err := q.app.FindRecordsByFilter(
+		"crawl_jobs",
+		"status = 'pending' && next_run <= {:now}",
+		"next_run",
+		50,
+		0,
+		dbx.Params{"now": types.NowDateTime().String()},
+	)
+	if err != nil {
+		return nil
+	}
+
+	for _, job := range jobs {
+		host := job.GetString("host")
+		if q.hosts[host] >= q.perHost {
+			continue
+		}
+
+		res, err := q.app.DB().NewQuery("UPDATE crawl_jobs SET status = 'running', updated = {:now} WHERE id = {:id} AND status = 'pending'").
+			Bind(dbx.Params{"id": job.Id, "now": types.NowDateTime().String()}).Execute()
+		if err != nil {
+			continue
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			continue
+		}
+
+		q.hosts[host]++
+		job.Set("status", "running")
+		return job
+	}
+	return nil
+}
+
+func (q *CrawlQueue) release(host string) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.hosts[host]--; q.hosts[host] <= 0 {
+		delete(q.hosts, host)
+	}
+}
+
+// runSafe runs job, failing it rather than the whole server when a crawler
+// or parser panics.
+func (q *CrawlQueue) runSafe(job *core.Record) {
+	defer func() {
+		if rec := recover(); rec != nil {
+			q.app.Logger().Warn("CrawlQueue: Panic", "id", job.Id, "url", job.GetString("url"), "error", fmt.Sprint(rec))
+			job.Set("status", "failed")
+			job.Set("error", "Internal error")
+			q.save(job)
+		}
+	}()
+	q.run(job)
+}
+
+func (q *CrawlQueue) run(job *core.Record) {
+	app := q.app
+
+	bookmark, err := app.FindRecordById("bookmarks", job.GetString("bookmark"))
+	if err != nil || bookmark.GetBool("deleted") {
+		job.Set("status", "failed")
+		job.Set("error", "bookmark not found")
+		q.save(job)
+		return
+	}
+
+	app.Logger().Debug("CrawlQueue: Running job", "id", job.Id, "kind", job.GetString("kind"), "url", job.GetString("url"))
+
+	if job.GetString("kind") == "cover" {
+		if err := ProcessCover(app, bookmark); err != nil {
+			q.retry(job, err)
+			return
+		}
+		q.done(job)
+		return
+	}
+
+	if job.GetString("kind") == "archive" {
+		if err := ArchiveBookmark(app, bookmark); err != nil {
+			q.retry(job, err)
+			return
+		}
+		q.done(job)
+		return
+	}
+
+	m, err := CrawlArticle(app, job.GetString("url"))
+	if err != nil {
+		q.retry(job, err)
+		return
+	}
+
+	if job.GetString("kind") == "refresh" {
+		if err := RefreshBookmark(app, bookmark, m); err != nil {
+			q.retry(job, err)
+			return
+		}
+		q.done(job)
+		return
+	}
+
+	assets := fetchAssets(app, m)
+
+	// the crawl may have taken a while, don't overwrite edits made meanwhile
+	bookmark, err = app.FindRecordById("bookmarks", bookmark.Id)
+	if err != nil || bookmark.GetBool("deleted") {
+		job.Set("status", "failed")
+		job.Set("error", "bookmark not found")
+		q.save(job)
+		return
+	}
+
+	overrides := overriddenFields(bookmark)
+	label := bookmark.GetString("label")
+	ApplyMeta(bookmark, m)
+	if slices.Contains(overrides, "label") {
+		bookmark.Set("label", label)
+	}
+	for field, f := range assets {
+		if !slices.Contains(overrides, field) {
+			bookmark.Set(field, f)
+		}
+	}
+	if err := app.Save(bookmark); err != nil {
+		q.retry(job, err)
+		return
+	}
+
+	q.done(job)
+}
+
+func (q *CrawlQueue) done(job *core.Record) {
+	job.Set("status", "done")
+	job.Set("error", "")
+	q.save(job)
+}
+
+func (q *CrawlQueue) retry(job *core.Record, err error) {
+	attempts := job.GetInt("attempts") + 1
+	job.Set("attempts", attempts)
+	job.Set("error", err.Error())
+
+	// robots.txt won't change its mind on a retry, and files can't be archived
+	if attempts >= jobMaxAttempts || errors.Is(err, lib.ErrDisallowed) || errors.Is(err, lib.ErrNotHTML) {
+		q.app.Logger().Warn("CrawlQueue: Job failed", "id", job.Id, "url", job.GetString("url"), "error", err.Error())
+		job.Set("status", "failed")
+		q.save(job)
+		return
+	}
+
+	backoff := jobBaseBackoff << (attempts - 1)
+	if backoff > jobMaxBackoff {
+		backoff = jobMaxBackoff
+	}
+	backoff += time.Duration(rand.Int63n(int64(backoff / 4)))
+
+	q.app.Logger().Debug("CrawlQueue: Retrying job", "id", job.Id, "attempts", attempts, "in", backoff.String())
+	job.Set("status", "pending")
+	job.Set("next_run", types.NowDateTime().Add(backoff))
+	q.save(job)
+}
+
+func (q *CrawlQueue) save(job *core.Record) {
+	if err := q.app.Save(job); err != nil {
+		q.app.Logger().Warn("CrawlQueue: Saving job", "id", job.Id, "error", err.Error())
+	}
+}
@@ -0,0 +1,145 @@
+package modules
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+
+	"dotpen.co/server/hooks/lib"
+	"github.com/PuerkitoBio/goquery"
+)
+
+// oembed.json follows the format of https://oembed.com/providers.json, so the
+// upstream list can be dropped in as-is.
+//
+//go:embed oembed.json
+var oembedJSON []byte
+
+type OEmbed struct {
+	Type         string `json:"type"`
+	Title        string `json:"title"`
+	AuthorName   string `json:"author_name"`
+	AuthorURL    string `json:"author_url"`
+	ProviderName string `json:"provider_name"`
+	ThumbnailURL string `json:"thumbnail_url"`
+	HTML         string `json:"html"`
+}
+
+type oembedProvider struct {
+	schemes  []*regexp.Regexp
+	endpoint string
+}
+
+var (
+	oembedProviders     []oembedProvider
+	oembedProvidersOnce sync.Once
+)
+
+func loadOEmbedProviders() {
+	var list []struct {
+		Endpoints []struct {
+			Schemes []string `json:"schemes"`
+			URL     string   `json:"url"`
+		} `json:"endpoints"`
+	}
+	if err := json.Unmarshal(oembedJSON, &list); err != nil {
+		return
+	}
+
+	for _, p := range list {
+		for _, e := range p.Endpoints {
+			op := oembedProvider{endpoint: strings.ReplaceAll(e.URL, "{format}", "json")}
+			for _, s := range e.Schemes {
+				pattern := regexp.QuoteMeta(s)
+				pattern = strings.ReplaceAll(pattern, `\*`, ".*")
+				pattern = regexp.MustCompile(`^https?://`).ReplaceAllString(pattern, "https?://")
+				if re, err := regexp.Compile("^" + pattern + "$"); err == nil {
+					op.schemes = append(op.schemes, re)
+				}
+			}
+			oembedProviders = append(oembedProviders, op)
+		}
+	}
+}
+
+// FindOEmbed returns the oEmbed endpoint for u from the bundled provider list,
+// or an empty string when no provider claims it.
+func FindOEmbed(u string) string {
+	oembedProvidersOnce.Do(loadOEmbedProviders)
+
+	for _, p := range oembedProviders {
+		for _, re := range p.schemes {
+			if re.MatchString(u) {
+				sep := "?"
+				if strings.Contains(p.endpoint, "?") {
+					sep = "&"
+				}
+				return p.endpoint + sep + "format=json&url=" + url.QueryEscape(u)
+			}
+		}
+	}
+	return ""
+}
+
+// DiscoverOEmbed returns the JSON oEmbed endpoint a page advertises through
+// <link rel="alternate" type="application/json+oembed">.
+func DiscoverOEmbed(doc *goquery.Document, u string) string {
+	href, ok := doc.Find(`link[type="application/json+oembed"]`).First().Attr("href")
+	if !ok || href == "" {
+		return ""
+	}
+
+	ref, err := url.Parse(strings.TrimSpace(href))
+	if err != nil {
+		return ""
+	}
+	base, err := url.Parse(u)
+	if err != nil {
+		return ""
+	}
+	return base.ResolveReference(ref).String()
+}
+
+func UseOEmbed(endpoint string) (*OEmbed, error) {
+	req, err := http.NewRequest("GET", endpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+
+	r, err := lib.UseProxy(lib.API(req))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Body.Close()
+
+	if r.StatusCode >= 400 {
+		return nil, fmt.Errorf("oembed: HTTP %d", r.StatusCode)
+	}
+
+	o := &OEmbed{}
+	if err := json.NewDecoder(r.Body).Decode(o); err != nil {
+		return nil, err
+	}
+	return o, nil
+}
+
+// Merge fills the fields of m that are still empty with the oEmbed data.
+func (o *OEmbed) Merge(m *MetaData) {
+	set := func(f *string, v string) {
+		if *f == "" && v != "" {
+			*f = v
+		}
+	}
+
+	set(&m.Title, o.Title)
+	set(&m.Author, o.AuthorName)
+	set(&m.Image, o.ThumbnailURL)
+	set(&m.Embed, o.HTML)
+	set(&m.SiteName, o.ProviderName)
+}
@@ -1,12 +1,18 @@
 package modules
 
 import (
+	"errors"
 	"io"
 	"log"
 	"net/http"
 	"net/url"
+	"time"
+
+	"dotpen.co/server/hooks/lib"
 )
 
+var proxyClient = lib.SafeClient(15 * time.Second)
+
 func UseProxy(w http.ResponseWriter, r *http.Request) {
 	targetURL := r.URL.Query().Get("url")
 	if targetURL == "" {
@@ -20,19 +26,28 @@ func UseProxy(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	resp, err := http.Get(targetURL)
+	if err := lib.CheckURL(parsedURL); err != nil {
+		http.Error(w, "URL not allowed", http.StatusForbidden)
+		return
+	}
+
+	resp, err := proxyClient.Get(targetURL)
 	if err != nil {
+		if errors.Is(err, lib.ErrBlockedAddress) {
+			http.Error(w, "URL not allowed", http.StatusForbidden)
+			return
+		}
 		http.Error(w, "Failed to get URL", http.StatusBadGateway)
 		return
 	}
 	defer resp.Body.Close()
 
-	w.WriteHeader(resp.StatusCode)
 	for key, values := range resp.Header {
 		for _, value := range values {
 			w.Header().Add(key, value)
 		}
 	}
+	w.WriteHeader(resp.StatusCode)
 
 	_, err = io.Copy(w, resp.Body)
 	if err != nil {
@@ -0,0 +1,351 @@
+package modules
+
+import (
+	"math"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/microcosm-cc/bluemonday"
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// Article is the readable main content of a page.
+type Article struct {
+	HTML  string `json:"html"`
+	Text  string `json:"text"`
+	Words int    `json:"words"`
+}
+
+// minArticleLength is the shortest text still considered an article; pages
+// below it are usually landing pages or apps.
+const minArticleLength = 140
+
+// wordsPerMinute is the reading speed reading times are estimated with.
+const wordsPerMinute = 230
+
+var (
+	rxUnlikely = regexp.MustCompile(`(?i)-ad-|ad-break|adbox|advert|agegate|banner|breadcrumb|combx|comment|community|cookie|cover-wrap|disqus|extra|footer|gdpr|header|legends|menu|modal|newsletter|outbrain|pager|pagination|popup|promo|related|remark|replies|rss|share|shoutbox|sidebar|skyscraper|social|sponsor|subscribe|supplemental|taboola|tags|tool|widget`)
+	rxMaybe    = regexp.MustCompile(`(?i)and|article|body|column|content|main|shadow`)
+	rxPositive = regexp.MustCompile(`(?i)article|body|content|entry|hentry|h-entry|main|page|pagination|post|text|blog|story`)
+	rxNegative = regexp.MustCompile(`(?i)-ad-|hidden|^hid$| hid$| hid |^hid |banner|combx|comment|com-|contact|footer|gdpr|masthead|media|meta|outbrain|promo|related|scroll|share|shoutbox|sidebar|skyscraper|sponsor|shopping|tags|widget`)
+	rxSpace    = regexp.MustCompile(`[ \t\r\f\v]+`)
+	rxLines    = regexp.MustCompile(`\n\s*\n\s*`)
+)
+
+// removed outright before scoring, never part of an article
+const junkSelector = "script, style, noscript, template, iframe, object, embed, form, input, button, select, textarea, nav, aside, footer, svg, canvas, dialog, link, meta"
+
+// articlePolicy is what article HTML is sanitized to before it is stored:
+// text formatting, lists, tables, links and images. Other elements are
+// unwrapped to their text, other attributes, including event handlers and
+// styles, dropped.
+var articlePolicy = func() *bluemonday.Policy {
+	p := bluemonday.NewPolicy()
+	p.AllowElements(
+		"div", "section", "article", "p", "br", "hr", "span",
+		"h1", "h2", "h3", "h4", "h5", "h6",
+		"blockquote", "pre", "code", "kbd", "samp", "var",
+		"em", "strong", "b", "i", "u", "s", "del", "ins", "mark", "small", "sub", "sup", "abbr", "cite", "q", "time",
+		"ul", "ol", "li", "dl", "dt", "dd",
+		"figure", "figcaption",
+		"table", "caption", "thead", "tbody", "tfoot", "tr", "th", "td",
+		"a", "img",
+	)
+	p.AllowAttrs("href").OnElements("a")
+	p.AllowAttrs("src", "alt").OnElements("img")
+	p.AllowAttrs("colspan", "rowspan").Matching(bluemonday.Integer).OnElements("th", "td")
+	p.AllowAttrs("title").Globally()
+	p.AllowURLSchemes("http", "https")
+	p.AllowRelativeURLs(true)
+	return p
+}()
+
+// ExtractArticle finds the main content of doc in the spirit of Mozilla's
+// Readability: paragraphs are scored by length and commas, the score flows to
+// their ancestors, and the best candidate with its related siblings is kept.
+// Relative links are resolved against base. The document is modified, so
+// call it after everything else has been read from doc.
+func ExtractArticle(doc *goquery.Document, base string) *Article {
+	bu, _ := url.Parse(base)
+
+	body := doc.Find("body").First()
+	if body.Length() == 0 {
+		return nil
+	}
+
+	body.Find(junkSelector).Remove()
+	body.Find(`[hidden], [aria-hidden="true"], [role="navigation"], [role="complementary"], [role="dialog"]`).Remove()
+	body.Find("*").Each(func(_ int, s *goquery.Selection) {
+		if s.Is("body, article, main, a, table, tbody, tr, td, th, thead") {
+			return
+		}
+		match := s.AttrOr("class", "") + " " + s.AttrOr("id", "")
+		if rxUnlikely.MatchString(match) && !rxMaybe.MatchString(match) {
+			s.Remove()
+		}
+	})
+
+	// divs holding only text behave like paragraphs
+	body.Find("div").Each(func(_ int, s *goquery.Selection) {
+		if s.Find("p, div, pre, blockquote, table, ul, ol, h1, h2, h3, h4, h5, h6, img, figure").Length() == 0 {
+			s.Get(0).Data, s.Get(0).DataAtom = "p", atom.P
+		}
+	})
+
+	scores := map[*html.Node]float64{}
+	var candidates []*html.Node
+	score := func(n *html.Node) {
+		if _, ok := scores[n]; ok {
+			return
+		}
+		scores[n] = initialScore(n)
+		candidates = append(candidates, n)
+	}
+
+	body.Find("p, pre, td, blockquote, li").Each(func(_ int, s *goquery.Selection) {
+		text := normalizeSpace(s.Text())
+		if len(text) < 25 {
+			return
+		}
+
+		p := s.Get(0).Parent
+		if p == nil || p.Type != html.ElementNode {
+			return
+		}
+		points := 1 + float64(strings.Count(text, ",")) + math.Min(float64(len(text))/100, 3)
+
+		score(p)
+		scores[p] += points
+		if gp := p.Parent; gp != nil && gp.Type == html.ElementNode {
+			score(gp)
+			scores[gp] += points / 2
+		}
+	})
+
+	var top *html.Node
+	for _, n := range candidates {
+		scores[n] *= 1 - linkDensity(goquery.NewDocumentFromNode(n).Selection)
+		if top == nil || scores[n] > scores[top] {
+			top = n
+		}
+	}
+	if top == nil {
+		top = body.Get(0)
+	}
+
+	// siblings sharing the parent often hold the rest of the article
+	var parts []*html.Node
+	threshold := math.Max(10, scores[top]*0.2)
+	if top.Parent != nil && top != body.Get(0) {
+		for n := top.Parent.FirstChild; n != nil; n = n.NextSibling {
+			if n.Type != html.ElementNode {
+				continue
+			}
+			if n == top {
+				parts = append(parts, n)
+				continue
+			}
+			if s, ok := scores[n]; ok && s >= threshold {
+				parts = append(parts, n)
+				continue
+			}
+			if n.Data == "p" {
+				sel := goquery.NewDocumentFromNode(n).Selection
+				text := normalizeSpace(sel.Text())
+				if ld := linkDensity(sel); (len(text) > 80 && ld < 0.25) || (len(text) > 0 && ld == 0 && strings.ContainsAny(text, ".!?")) {
+					parts = append(parts, n)
+				}
+			}
+		}
+	} else {
+		parts = append(parts, top)
+	}
+
+	out := &html.Node{Type: html.ElementNode, Data: "div"}
+	for _, n := range parts {
+		if n.Data == "body" {
+			for n.FirstChild != nil {
+				c := n.FirstChild
+				n.RemoveChild(c)
+				out.AppendChild(c)
+			}
+			continue
+		}
+		n.Parent.RemoveChild(n)
+		out.AppendChild(n)
+	}
+
+	article := goquery.NewDocumentFromNode(out).Selection
+	cleanArticle(article, bu)
+
+	text := articleText(out)
+	if len(text) < minArticleLength {
+		return nil
+	}
+
+	content, err := goquery.OuterHtml(article)
+	if err != nil {
+		return nil
+	}
+
+	return &Article{
+		HTML:  articlePolicy.Sanitize(content),
+		Text:  text,
+		Words: len(strings.Fields(text)),
+	}
+}
+
+func initialScore(n *html.Node) float64 {
+	s := 0.0
+	switch n.Data {
+	case "div", "article", "main", "section":
+		s = 5
+	case "pre", "td", "blockquote":
+		s = 3
+	case "address", "ol", "ul", "dl", "dd", "dt", "li", "form":
+		s = -3
+	case "h1", "h2", "h3", "h4", "h5", "h6", "th":
+		s = -5
+	}
+
+	for _, a := range n.Attr {
+		if a.Key != "class" && a.Key != "id" {
+			continue
+		}
+		if rxNegative.MatchString(a.Val) {
+			s -= 25
+		}
+		if rxPositive.MatchString(a.Val) {
+			s += 25
+		}
+	}
+	return s
+}
+
+// linkDensity is the share of the text of s that sits inside links.
+func linkDensity(s *goquery.Selection) float64 {
+	total := len(normalizeSpace(s.Text()))
+	if total == 0 {
+		return 0
+	}
+	links := 0
+	s.Find("a").Each(func(_ int, a *goquery.Selection) {
+		links += len(normalizeSpace(a.Text()))
+	})
+	return float64(links) / float64(total)
+}
+
+// cleanArticle strips what is left of page chrome from the article, link
+// lists and empty blocks, and resolves its links against base.
+func cleanArticle(s *goquery.Selection, base *url.URL) {
+	s.Find("ul, ol, div, section, table").Each(func(_ int, el *goquery.Selection) {
+		text := normalizeSpace(el.Text())
+		if el.Find("img, pre, figure").Length() == 0 && (text == "" || (linkDensity(el) > 0.5 && len(text) < 500)) {
+			el.Remove()
+		}
+	})
+	s.Find("p").Each(func(_ int, el *goquery.Selection) {
+		if normalizeSpace(el.Text()) == "" && el.Find("img").Length() == 0 {
+			el.Remove()
+		}
+	})
+
+	s.Find("img").Each(func(_ int, el *goquery.Selection) {
+		if v := el.AttrOr("data-src", ""); v != "" && el.AttrOr("src", "") == "" {
+			el.SetAttr("src", v)
+		}
+	})
+
+	// the rest is left to articlePolicy
+	s.Find("[href], [src]").Each(func(_ int, el *goquery.Selection) {
+		n := el.Get(0)
+		attrs := n.Attr[:0]
+		for _, a := range n.Attr {
+			if a.Key == "href" || a.Key == "src" {
+				a.Val = safeURL(base, a.Val)
+				if a.Val == "" {
+					continue
+				}
+			}
+			attrs = append(attrs, a)
+		}
+		n.Attr = attrs
+	})
+}
+
+// safeURL resolves ref against base and drops anything but http(s) and
+// in-page anchors, so javascript: and data: links can't sneak through.
+func safeURL(base *url.URL, ref string) string {
+	ref = strings.TrimSpace(ref)
+	if strings.HasPrefix(ref, "#") {
+		return ref
+	}
+	u, err := url.Parse(ref)
+	if err != nil {
+		return ""
+	}
+	if base != nil {
+		u = base.ResolveReference(u)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return ""
+	}
+	return u.String()
+}
+
+// articleText renders n as plain text with blank lines between blocks.
+func articleText(n *html.Node) string {
+	var b strings.Builder
+	var walk func(*html.Node)
+	walk = func(n *html.Node) {
+		switch n.Type {
+		case html.TextNode:
+			b.WriteString(n.Data)
+			return
+		case html.ElementNode:
+			if n.Data == "br" {
+				b.WriteString("\n")
+				return
+			}
+		}
+
+		block := n.Type == html.ElementNode && isBlock(n.Data)
+		if block {
+			b.WriteString("\n\n")
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+		if block {
+			b.WriteString("\n\n")
+		}
+	}
+	walk(n)
+
+	lines := strings.Split(b.String(), "\n")
+	for i, l := range lines {
+		lines[i] = strings.TrimSpace(rxSpace.ReplaceAllString(l, " "))
+	}
+	return strings.TrimSpace(rxLines.ReplaceAllString(strings.Join(lines, "\n"), "\n\n"))
+}
+
+func isBlock(tag string) bool {
+	switch tag {
+	case "p", "div", "section", "article", "main", "blockquote", "pre", "ul", "ol", "li",
+		"h1", "h2", "h3", "h4", "h5", "h6", "table", "tr", "figure", "figcaption", "hr", "dl", "dt", "dd":
+		return true
+	}
+	return false
+}
+
+func normalizeSpace(s string) string {
+	return strings.Join(strings.Fields(s), " ")
+}
+
+// readingTime estimates the minutes it takes to read words.
+func readingTime(words int) int {
+	return (words + wordsPerMinute - 1) / wordsPerMinute
+}
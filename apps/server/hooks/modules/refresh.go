@@ -0,0 +1,218 @@
+package modules
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"time"
+
+	"dotpen.co/server/hooks/lib"
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tools/filesystem"
+	"github.com/pocketbase/pocketbase/tools/types"
+)
+
+// maxChanges is how many entries a bookmark's change log keeps.
+const maxChanges = 50
+
+// refreshFields are the bookmark fields a refresh may update, unless the user
+// edited them by hand.
+var refreshFields = []string{"label", "favicon", "cover"}
+
+// CrawlSnapshot is what a bookmark was last filled in from, kept in its crawl
+// field so a refresh can tell which values changed at the source.
+type CrawlSnapshot struct {
+	Title   string `json:"title"`
+	Favicon string `json:"favicon"`
+	Image   string `json:"image"`
+}
+
+// Change is one entry of a bookmark's change log.
+type Change struct {
+	At    types.DateTime `json:"at"`
+	Field string         `json:"field"`
+	From  string         `json:"from"`
+	To    string         `json:"to"`
+}
+
+// MarkOverrides adds the refreshable fields changed by a user's update
+// request to the bookmark's overrides, so later refreshes leave them alone.
+// Only fields among submitted, the keys of the request body (modifiers like
+// "cover-" included), count. Clients can hand fields back to the refresher
+// by updating overrides themselves.
+func MarkOverrides(bookmark *core.Record, submitted []string) {
+	orig := bookmark.Original()
+	if fmt.Sprint(bookmark.Get("overrides")) != fmt.Sprint(orig.Get("overrides")) {
+		return
+	}
+
+	overrides := overriddenFields(bookmark)
+	changed := false
+	for _, f := range refreshFields {
+		touched := slices.ContainsFunc(submitted, func(k string) bool { return strings.Trim(k, "+-") == f })
+		if touched && fmt.Sprint(bookmark.Get(f)) != fmt.Sprint(orig.Get(f)) && !slices.Contains(overrides, f) {
+			overrides = append(overrides, f)
+			changed = true
+		}
+	}
+	if changed {
+		bookmark.Set("overrides", overrides)
+	}
+}
+
+func overriddenFields(bookmark *core.Record) []string {
+	overrides := []string{}
+	_ = bookmark.UnmarshalJSONField("overrides", &overrides)
+	return overrides
+}
+
+// RefreshStale queues a refresh for bookmarks not refreshed within
+// REFRESH_AGE (default 720h), at most REFRESH_BATCH (default 100) per run.
+// Broken links are skipped, the link checker owns those.
+func RefreshStale(app core.App) {
+	cutoff := types.NowDateTime().Add(-lib.EnvDuration("REFRESH_AGE", 30*24*time.Hour)).String()
+
+	records, err := app.FindRecordsByFilter(
+		"bookmarks",
+		"deleted = false && broken = false && link != '' && ((refreshed = '' && created < {:cutoff}) || (refreshed != '' && refreshed < {:cutoff}))",
+		"refreshed,created",
+		envInt("REFRESH_BATCH", 100),
+		0,
+		dbx.Params{"cutoff": cutoff},
+	)
+	if err != nil {
+		app.Logger().Error("Cron: Refresh stale bookmarks", "error", err.Error())
+		return
+	}
+
+	app.Logger().Debug("Cron: Refresh stale bookmarks", "count", len(records))
+
+	for _, r := range records {
+		// marked right away, so failing links aren't picked up every run
+		r.Set("refreshed", types.NowDateTime())
+		if err := app.Save(r); err != nil {
+			app.Logger().Warn("Cron: Refresh stale bookmarks", "id", r.Id, "error", err.Error())
+			continue
+		}
+		if err := EnqueueRefresh(app, r); err != nil {
+			app.Logger().Warn("Cron: Refresh stale bookmarks", "id", r.Id, "error", err.Error())
+		}
+	}
+}
+
+// RefreshBookmark applies freshly crawled MetaData to a bookmark: fields the
+// user overrode are kept, the others are updated when the source changed, and
+// every update is written to the change log. Images count as changed when
+// their content does, whatever their URL.
+//
+// Bookmarks without a snapshot, such as the ones made by clients, take their
+// first one here. There is nothing to tell a source change from the user's
+// choice yet, so only their empty fields are filled in.
+func RefreshBookmark(app core.App, bookmark *core.Record, m *MetaData) error {
+	// the crawl may have taken a while, don't overwrite edits made meanwhile
+	bookmark, err := app.FindRecordById("bookmarks", bookmark.Id)
+	if err != nil {
+		return err
+	}
+
+	prev := &CrawlSnapshot{}
+	hasPrev := bookmark.UnmarshalJSONField("crawl", prev) == nil && *prev != CrawlSnapshot{}
+	overrides := overriddenFields(bookmark)
+
+	changes := []*Change{}
+	_ = bookmark.UnmarshalJSONField("changes", &changes)
+	changed := 0
+	logChange := func(field, from, to string) {
+		changes = append(changes, &Change{At: types.NowDateTime(), Field: field, From: from, To: to})
+		changed++
+	}
+
+	label := bookmark.GetString("label")
+	switch {
+	case slices.Contains(overrides, "label") || m.Title == "" || m.Title == label:
+	case !hasPrev && label != "":
+	case !hasPrev || m.Title != prev.Title:
+		logChange("label", label, m.Title)
+		bookmark.Set("label", m.Title)
+	}
+
+	for field, src := range map[string][2]string{"favicon": {prev.Favicon, m.Favicon}, "cover": {prev.Image, m.Image}} {
+		from, to := src[0], src[1]
+		if slices.Contains(overrides, field) || to == "" || (!hasPrev && bookmark.GetString(field) != "") {
+			continue
+		}
+
+		f, err := fetchBookmarkAsset(app, m, field, to)
+		if err != nil {
+			app.Logger().Debug("Refresh: Asset download", "field", field, "url", to, "error", err.Error())
+			continue
+		}
+		same, err := sameFile(app, bookmark, field, f)
+		if err != nil {
+			app.Logger().Debug("Refresh: Asset compare", "field", field, "error", err.Error())
+			continue
+		}
+		if same {
+			continue
+		}
+		logChange(field, from, to)
+		bookmark.Set(field, f)
+	}
+
+	if m.Article != nil && bookmark.GetString("text") == "" {
+		applyArticle(bookmark, m.Article)
+	}
+
+	if len(changes) > maxChanges {
+		changes = changes[len(changes)-maxChanges:]
+	}
+	bookmark.Set("changes", changes)
+	bookmark.Set("crawl", snapshotOf(m))
+	applyDetails(bookmark, m)
+	bookmark.Set("refreshed", types.NowDateTime())
+
+	app.Logger().Debug("Refresh: Bookmark", "id", bookmark.Id, "changes", changed)
+	return app.Save(bookmark)
+}
+
+// sameFile reports whether the file stored in a record's field has the
+// content of f. An empty field has nothing in common with it.
+func sameFile(app core.App, record *core.Record, field string, f *filesystem.File) (bool, error) {
+	stored, err := storedFile(app, record, field)
+	if err != nil || stored == nil {
+		return false, err
+	}
+	b, err := readUpload(f)
+	if err != nil {
+		return false, err
+	}
+	return bytes.Equal(stored, b), nil
+}
+
+// storedFile reads the file stored in a record's field, nil when empty.
+func storedFile(app core.App, record *core.Record, field string) ([]byte, error) {
+	name := record.GetString(field)
+	if name == "" {
+		return nil, nil
+	}
+
+	fsys, err := app.NewFilesystem()
+	if err != nil {
+		return nil, err
+	}
+	defer fsys.Close()
+
+	r, err := fsys.GetReader(record.BaseFilesPath() + "/" + name)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return io.ReadAll(r)
+}
+
+func snapshotOf(m *MetaData) *CrawlSnapshot {
+	return &CrawlSnapshot{Title: m.Title, Favicon: m.Favicon, Image: m.Image}
+}
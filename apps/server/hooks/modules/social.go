@@ -0,0 +1,448 @@
+package modules
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Post is a post on a social network, with the author's handle
+// (@user@instance, or the Bluesky handle) and its reactions.
+type Post struct {
+	Site    string `json:"site"`
+	Handle  string `json:"handle"`
+	Name    string `json:"name,omitempty"`
+	Replies int    `json:"replies"`
+	Reposts int    `json:"reposts"`
+	Likes   int    `json:"likes"`
+}
+
+// activityAccept asks ActivityPub servers for the object behind a page.
+const activityAccept = `application/activity+json, application/ld+json; profile="https://www.w3.org/ns/activitystreams"`
+
+var (
+	// status pages of Mastodon and compatible servers (/@user/123,
+	// /users/user/statuses/123), Misskey (/notes/id) and Pleroma (/notice/id)
+	rxFediversePath = regexp.MustCompile(`^/(?:@[^/]+/(\d+)|users/[^/]+/statuses/(\d+)|notes/[0-9a-z]+|notice/[0-9A-Za-z]+)/?$`)
+	rxBlueskyPath   = regexp.MustCompile(`^/profile/([^/]+)/post/([0-9a-z]+)/?$`)
+)
+
+// mastodonAPISoftware are the servers implementing the Mastodon client API.
+var mastodonAPISoftware = map[string]bool{"mastodon": true, "hometown": true, "glitchsoc": true, "fedibird": true, "pleroma": true, "akkoma": true, "gotosocial": true}
+
+// nodeInfoTTL is how long the software of a host is remembered, and
+// nodeInfoRetry how long a failed lookup is; maxNodeInfoHosts caps the cache.
+const (
+	nodeInfoTTL      = 24 * time.Hour
+	nodeInfoRetry    = 10 * time.Minute
+	maxNodeInfoHosts = 1000
+)
+
+type nodeInfo struct {
+	software string
+	expires  time.Time
+}
+
+var (
+	nodeInfoMu    sync.Mutex
+	nodeInfoCache = map[string]*nodeInfo{}
+)
+
+func init() {
+	RegisterCrawler(&HostCrawler{Key: "bluesky", Hosts: []string{"bsky.app"}, Path: rxBlueskyPath, Use: UseBluesky})
+	// any host, so it goes last
+	RegisterCrawler(&HostCrawler{Key: "activitypub", Path: rxFediversePath, Rank: -1, Use: UseActivityPub})
+}
+
+// UseActivityPub reads a fediverse post as an ActivityPub object. Servers
+// refusing unsigned requests are asked through the Mastodon API instead, when
+// their nodeinfo says they have it. Anything else goes to UseDefault.
+func UseActivityPub(u string) (*MetaData, error) {
+	pu, err := url.Parse(u)
+	if err != nil {
+		return nil, err
+	}
+
+	if m, err := activityPubNote(u); err == nil {
+		return m, nil
+	}
+
+	if p := rxFediversePath.FindStringSubmatch(pu.Path); p != nil && p[1]+p[2] != "" && mastodonAPISoftware[nodeInfoSoftware(pu)] {
+		if m, err := mastodonStatus(u, pu, p[1]+p[2]); err == nil {
+			return m, nil
+		}
+	}
+	return UseDefault(u)
+}
+
+func getActivity(u string, v any) error {
+	req, err := http.NewRequest("GET", u, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", activityAccept)
+	return decodeJSON(req, v)
+}
+
+func activityPubNote(u string) (*MetaData, error) {
+	var note struct {
+		Type         string          `json:"type"`
+		Content      string          `json:"content"`
+		Summary      string          `json:"summary"`
+		Published    string          `json:"published"`
+		AttributedTo json.RawMessage `json:"attributedTo"`
+		Attachment   []struct {
+			MediaType string `json:"mediaType"`
+			URL       any    `json:"url"`
+		} `json:"attachment"`
+		Replies struct {
+			TotalItems int `json:"totalItems"`
+		} `json:"replies"`
+		Likes struct {
+			TotalItems int `json:"totalItems"`
+		} `json:"likes"`
+		Shares struct {
+			TotalItems int `json:"totalItems"`
+		} `json:"shares"`
+	}
+	if err := getActivity(u, &note); err != nil {
+		return nil, err
+	}
+	switch note.Type {
+	case "Note", "Article", "Page", "Question":
+	default:
+		return nil, fmt.Errorf("not a post: %q", note.Type)
+	}
+
+	var actor struct {
+		ID                string `json:"id"`
+		PreferredUsername string `json:"preferredUsername"`
+		Name              string `json:"name"`
+		Icon              struct {
+			URL string `json:"url"`
+		} `json:"icon"`
+	}
+	var actorID string
+	if json.Unmarshal(note.AttributedTo, &actorID) != nil {
+		_ = json.Unmarshal(note.AttributedTo, &actor)
+		actorID = actor.ID
+	}
+	if actorID != "" {
+		_ = getActivity(actorID, &actor)
+	}
+
+	handle := ""
+	if au, err := url.Parse(actorID); err == nil && actor.PreferredUsername != "" {
+		handle = webFingerHandle(au, actor.PreferredUsername)
+	}
+
+	image := ""
+	for _, a := range note.Attachment {
+		if s, ok := a.URL.(string); ok && strings.HasPrefix(a.MediaType, "image/") {
+			image = s
+			break
+		}
+	}
+
+	pu, _ := url.Parse(u)
+	text := htmlText(note.Content)
+	if note.Summary != "" {
+		// content warnings hide the text until opened
+		text = "CW: " + htmlText(note.Summary)
+	}
+
+	return &MetaData{
+		Title:       postTitle(text, handle),
+		Description: truncate(text, 300),
+		Image:       image,
+		Favicon:     actor.Icon.URL,
+		URL:         u,
+		Author:      handle,
+		Date:        note.Published,
+		SiteName:    pu.Hostname(),
+		Type:        "page",
+		Post: &Post{
+			Site:    "activitypub",
+			Handle:  handle,
+			Name:    actor.Name,
+			Replies: note.Replies.TotalItems,
+			Reposts: note.Shares.TotalItems,
+			Likes:   note.Likes.TotalItems,
+		},
+	}, nil
+}
+
+// webFingerHandle returns the handle of the actor user on the server at au.
+// Servers may hand out handles on another domain than they run on, so the
+// domain is taken from WebFinger, falling back to the server's own.
+func webFingerHandle(au *url.URL, user string) string {
+	handle := "@" + user + "@" + au.Hostname()
+
+	var wf struct {
+		Subject string `json:"subject"`
+	}
+	q := url.Values{"resource": {"acct:" + user + "@" + au.Hostname()}}
+	if err := getJSON(au.Scheme+"://"+au.Host+"/.well-known/webfinger?"+q.Encode(), &wf); err != nil {
+		return handle
+	}
+	if acct, ok := strings.CutPrefix(wf.Subject, "acct:"); ok && strings.Contains(acct, "@") {
+		return "@" + acct
+	}
+	return handle
+}
+
+// nodeInfoSoftware returns the server software of a host as announced in its
+// nodeinfo. Answers are remembered for nodeInfoTTL, failures for
+// nodeInfoRetry only.
+func nodeInfoSoftware(pu *url.URL) string {
+	host := strings.ToLower(pu.Host)
+
+	nodeInfoMu.Lock()
+	ni := nodeInfoCache[host]
+	nodeInfoMu.Unlock()
+	if ni != nil && time.Now().Before(ni.expires) {
+		return ni.software
+	}
+
+	var wk struct {
+		Links []struct {
+			Rel  string `json:"rel"`
+			Href string `json:"href"`
+		} `json:"links"`
+	}
+	name := ""
+	ttl := nodeInfoTTL
+	if err := getJSON(pu.Scheme+"://"+pu.Host+"/.well-known/nodeinfo", &wk); err != nil {
+		ttl = nodeInfoRetry
+	}
+	for _, l := range wk.Links {
+		if !strings.HasPrefix(l.Rel, "http://nodeinfo.diaspora.software/ns/schema/") {
+			continue
+		}
+		var ni struct {
+			Software struct {
+				Name string `json:"name"`
+			} `json:"software"`
+		}
+		if err := getJSON(l.Href, &ni); err != nil {
+			ttl = nodeInfoRetry
+			continue
+		}
+		name = strings.ToLower(ni.Software.Name)
+		ttl = nodeInfoTTL
+		break
+	}
+
+	now := time.Now()
+	nodeInfoMu.Lock()
+	defer nodeInfoMu.Unlock()
+	if len(nodeInfoCache) >= maxNodeInfoHosts {
+		for h, e := range nodeInfoCache {
+			if now.After(e.expires) {
+				delete(nodeInfoCache, h)
+			}
+		}
+	}
+	// still full of live entries, make room for this one
+	for h := range nodeInfoCache {
+		if len(nodeInfoCache) < maxNodeInfoHosts {
+			break
+		}
+		delete(nodeInfoCache, h)
+	}
+	nodeInfoCache[host] = &nodeInfo{software: name, expires: now.Add(ttl)}
+	return name
+}
+
+func mastodonStatus(u string, pu *url.URL, id string) (*MetaData, error) {
+	var s struct {
+		Content     string `json:"content"`
+		SpoilerText string `json:"spoiler_text"`
+		CreatedAt   string `json:"created_at"`
+		Replies     int    `json:"replies_count"`
+		Reblogs     int    `json:"reblogs_count"`
+		Favourites  int    `json:"favourites_count"`
+		Account     struct {
+			Acct        string `json:"acct"`
+			DisplayName string `json:"display_name"`
+			Avatar      string `json:"avatar"`
+		} `json:"account"`
+		Media []struct {
+			Type       string `json:"type"`
+			URL        string `json:"url"`
+			PreviewURL string `json:"preview_url"`
+		} `json:"media_attachments"`
+	}
+	if err := getJSON(pu.Scheme+"://"+pu.Host+"/api/v1/statuses/"+id, &s); err != nil {
+		return nil, err
+	}
+
+	// local accounts come without their domain
+	handle := "@" + s.Account.Acct
+	if !strings.Contains(s.Account.Acct, "@") {
+		handle = webFingerHandle(pu, s.Account.Acct)
+	}
+
+	image := ""
+	for _, a := range s.Media {
+		if a.Type == "image" {
+			image = a.URL
+		} else {
+			image = a.PreviewURL
+		}
+		if image != "" {
+			break
+		}
+	}
+
+	text := htmlText(s.Content)
+	if s.SpoilerText != "" {
+		text = "CW: " + s.SpoilerText
+	}
+
+	return &MetaData{
+		Title:       postTitle(text, handle),
+		Description: truncate(text, 300),
+		Image:       image,
+		Favicon:     s.Account.Avatar,
+		URL:         u,
+		Author:      handle,
+		Date:        s.CreatedAt,
+		SiteName:    pu.Hostname(),
+		Type:        "page",
+		Post: &Post{
+			Site:    "activitypub",
+			Handle:  handle,
+			Name:    s.Account.DisplayName,
+			Replies: s.Replies,
+			Reposts: s.Reblogs,
+			Likes:   s.Favourites,
+		},
+	}, nil
+}
+
+// postTitle is the text of a post cut to a title, or who posted it for posts
+// with nothing but media.
+func postTitle(text, handle string) string {
+	if text != "" {
+		return truncate(text, 200)
+	}
+	if handle != "" {
+		return "Post by " + handle
+	}
+	return "Post"
+}
+
+// UseBluesky reads a Bluesky post from the public AppView.
+func UseBluesky(u string) (*MetaData, error) {
+	pu, err := url.Parse(u)
+	if err != nil {
+		return nil, err
+	}
+	p := rxBlueskyPath.FindStringSubmatch(pu.Path)
+	if p == nil {
+		return UseDefault(u)
+	}
+	actor, rkey := p[1], p[2]
+
+	const appView = "https://public.api.bsky.app/xrpc/"
+	if !strings.HasPrefix(actor, "did:") {
+		var id struct {
+			DID string `json:"did"`
+		}
+		if err := getJSON(appView+"com.atproto.identity.resolveHandle?handle="+url.QueryEscape(actor), &id); err != nil || id.DID == "" {
+			return UseDefault(u)
+		}
+		actor = id.DID
+	}
+
+	type images []struct {
+		Fullsize string `json:"fullsize"`
+	}
+	type media struct {
+		Images    images `json:"images"`
+		Thumbnail string `json:"thumbnail"`
+		External  struct {
+			Thumb string `json:"thumb"`
+		} `json:"external"`
+	}
+	var d struct {
+		Thread struct {
+			Post struct {
+				Author struct {
+					Handle      string `json:"handle"`
+					DisplayName string `json:"displayName"`
+					Avatar      string `json:"avatar"`
+				} `json:"author"`
+				Record struct {
+					Text      string `json:"text"`
+					CreatedAt string `json:"createdAt"`
+				} `json:"record"`
+				Embed *struct {
+					media
+					Media *media `json:"media"`
+				} `json:"embed"`
+				Replies int `json:"replyCount"`
+				Reposts int `json:"repostCount"`
+				Likes   int `json:"likeCount"`
+			} `json:"post"`
+		} `json:"thread"`
+	}
+	uri := "at://" + actor + "/app.bsky.feed.post/" + rkey
+	if err := getJSON(appView+"app.bsky.feed.getPostThread?depth=0&parentHeight=0&uri="+url.QueryEscape(uri), &d); err != nil {
+		return UseDefault(u)
+	}
+	post := d.Thread.Post
+	if post.Author.Handle == "" {
+		return UseDefault(u)
+	}
+
+	// images, a video, a link card, or media next to a quoted post
+	image := ""
+	if e := post.Embed; e != nil {
+		for _, md := range []*media{&e.media, e.Media} {
+			if md == nil {
+				continue
+			}
+			switch {
+			case len(md.Images) > 0:
+				image = md.Images[0].Fullsize
+			case md.Thumbnail != "":
+				image = md.Thumbnail
+			case md.External.Thumb != "":
+				image = md.External.Thumb
+			}
+			if image != "" {
+				break
+			}
+		}
+	}
+
+	handle := "@" + post.Author.Handle
+	text := normalizeSpace(post.Record.Text)
+
+	return &MetaData{
+		Title:       postTitle(text, handle),
+		Description: truncate(text, 300),
+		Image:       image,
+		Favicon:     post.Author.Avatar,
+		URL:         u,
+		Author:      handle,
+		Date:        post.Record.CreatedAt,
+		SiteName:    "Bluesky",
+		Type:        "page",
+		Post: &Post{
+			Site:    "bluesky",
+			Handle:  handle,
+			Name:    post.Author.DisplayName,
+			Replies: post.Replies,
+			Reposts: post.Reposts,
+			Likes:   post.Likes,
+		},
+	}, nil
+}
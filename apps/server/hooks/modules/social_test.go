@@ -0,0 +1,73 @@
+package modules
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestUseActivityPub(t *testing.T) {
+	useFixtures(t, fixtures{
+		"social.example.org/users/alice/statuses/111222333444555666": "activitypub_note.json",
+		"social.example.org/users/alice":                             "activitypub_actor.json",
+		"social.example.org/.well-known/webfinger":                   "webfinger.json",
+	})
+
+	m, err := UseActivityPub("https://social.example.org/users/alice/statuses/111222333444555666")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Title != "Just shipped #golang 1.22 support!" || m.Image != "https://files.social.example.org/media/screenshot.png" {
+		t.Errorf("got title %q, image %q", m.Title, m.Image)
+	}
+	// the handle's domain comes from WebFinger, not the server's host name
+	want := &Post{Site: "activitypub", Handle: "@alice@example.org", Name: "Alice", Replies: 3, Reposts: 5, Likes: 21}
+	if !reflect.DeepEqual(m.Post, want) {
+		t.Errorf("got post %+v, want %+v", m.Post, want)
+	}
+	if m.Favicon != "https://files.social.example.org/accounts/avatars/alice.png" || m.SiteName != "social.example.org" {
+		t.Errorf("got favicon %q, site %q", m.Favicon, m.SiteName)
+	}
+}
+
+func TestUseActivityPubMastodonAPI(t *testing.T) {
+	// the ActivityPub object needs a signed request, the Mastodon API doesn't
+	useFixtures(t, fixtures{
+		"secure.example.net/.well-known/nodeinfo":         "nodeinfo.json",
+		"secure.example.net/nodeinfo/2.0":                 "nodeinfo_2.0.json",
+		"secure.example.net/api/v1/statuses/109876543210": "mastodon_status.json",
+	})
+
+	m, err := UseActivityPub("https://secure.example.net/@bob/109876543210")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Title != "CW: Spoilers for the finale" || m.Image != "https://secure.example.net/media/clip.png" {
+		t.Errorf("got title %q, image %q", m.Title, m.Image)
+	}
+	want := &Post{Site: "activitypub", Handle: "@bob@secure.example.net", Name: "Bob", Replies: 2, Reposts: 1, Likes: 8}
+	if !reflect.DeepEqual(m.Post, want) {
+		t.Errorf("got post %+v, want %+v", m.Post, want)
+	}
+}
+
+func TestUseBluesky(t *testing.T) {
+	useFixtures(t, fixtures{
+		"public.api.bsky.app/xrpc/com.atproto.identity.resolveHandle": "bluesky_handle.json",
+		"public.api.bsky.app/xrpc/app.bsky.feed.getPostThread":        "bluesky_thread.json",
+	})
+
+	m, err := UseBluesky("https://bsky.app/profile/alice.bsky.social/post/3kxyz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Title != "Look at this quote" || m.Date != "2024-04-02T12:00:00.000Z" || m.SiteName != "Bluesky" {
+		t.Errorf("got title %q, date %q, site %q", m.Title, m.Date, m.SiteName)
+	}
+	if m.Image != "https://cdn.bsky.app/img/feed_fullsize/plain/did:plc:abc123xyz/b@jpeg" {
+		t.Errorf("got image %q", m.Image)
+	}
+	want := &Post{Site: "bluesky", Handle: "@alice.bsky.social", Name: "Alice", Replies: 4, Reposts: 6, Likes: 40}
+	if !reflect.DeepEqual(m.Post, want) {
+		t.Errorf("got post %+v, want %+v", m.Post, want)
+	}
+}
@@ -0,0 +1,103 @@
+package modules
+
+import (
+	"io"
+	"net/http"
+	"regexp"
+
+	"dotpen.co/server/hooks/lib"
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+)
+
+var rxFilename = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// UseBookmarkWARC serves the WARC file recorded while archiving a bookmark
+// owned by user.
+func UseBookmarkWARC(w http.ResponseWriter, r *http.Request, app core.App, user *core.Record) {
+	bookmark := findOwnedBookmark(app, r.PathValue("id"), user)
+	if bookmark == nil || bookmark.GetString("warc") == "" {
+		w.Header().Set("Content-Type", "application/json")
+		http.Error(w, `{"error":"No WARC"}`, 404)
+		return
+	}
+
+	fsys, err := app.NewFilesystem()
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		http.Error(w, `{"error":"failed"}`, 500)
+		return
+	}
+	defer fsys.Close()
+
+	name := bookmark.GetString("warc")
+	w.Header().Set("Content-Type", "application/warc")
+	w.Header().Set("Content-Disposition", "attachment; filename="+bookmark.Id+".warc.gz")
+
+	if err := fsys.Serve(w, r, bookmark.BaseFilesPath()+"/"+name, name); err != nil {
+		app.Logger().Warn("GET /api/bookmarks/{id}/warc: Serve", "id", bookmark.Id, "error", err.Error())
+		w.Header().Set("Content-Type", "application/json")
+		http.Error(w, `{"error":"failed"}`, 500)
+	}
+}
+
+// UseCollectionWARC streams the WARC files of every archived bookmark in a
+// collection owned by user as one .warc.gz. Each record is its own gzip
+// member, so the files are simply concatenated.
+func UseCollectionWARC(w http.ResponseWriter, r *http.Request, app core.App, user *core.Record) {
+	col, err := app.FindRecordById("collections", r.PathValue("id"))
+	if err != nil || col.GetString("user") != user.Id {
+		w.Header().Set("Content-Type", "application/json")
+		http.Error(w, `{"error":"Collection not found"}`, 404)
+		return
+	}
+
+	bookmarks, err := app.FindRecordsByFilter(
+		"bookmarks",
+		"collection = {:collection} && deleted = false && warc != ''",
+		"created",
+		0,
+		0,
+		dbx.Params{"collection": col.Id},
+	)
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		http.Error(w, `{"error":"failed"}`, 500)
+		return
+	}
+
+	fsys, err := app.NewFilesystem()
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		http.Error(w, `{"error":"failed"}`, 500)
+		return
+	}
+	defer fsys.Close()
+
+	name := rxFilename.ReplaceAllString(col.GetString("name"), "-")
+	if name == "" || name == "-" {
+		name = col.Id
+	}
+	name += ".warc.gz"
+
+	w.Header().Set("Content-Type", "application/warc")
+	w.Header().Set("Content-Disposition", "attachment; filename="+name)
+
+	if err := lib.WriteWARCInfo(w, name); err != nil {
+		return
+	}
+	for _, b := range bookmarks {
+		f, err := fsys.GetReader(b.BaseFilesPath() + "/" + b.GetString("warc"))
+		if err != nil {
+			app.Logger().Warn("GET /api/collections/{id}/warc: Read", "id", b.Id, "error", err.Error())
+			continue
+		}
+		_, err = io.Copy(w, f)
+		f.Close()
+		if err != nil {
+			return
+		}
+	}
+
+	app.Logger().Debug("GET /api/collections/{id}/warc", "id", col.Id, "bookmarks", len(bookmarks))
+}
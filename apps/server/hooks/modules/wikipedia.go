@@ -0,0 +1,120 @@
+package modules
+
+import (
+	"net/url"
+	"regexp"
+	"slices"
+	"strings"
+
+	"dotpen.co/server/hooks/lib"
+)
+
+// wikiProjects are the Wikimedia projects with a wiki per language
+// subdomain, all serving the same REST API.
+var wikiProjects = []string{"wikipedia", "wiktionary", "wikiquote", "wikibooks", "wikisource", "wikinews", "wikiversity", "wikivoyage"}
+
+// wikimediaSites are the single-language wikis on wikimedia.org, by subdomain.
+var wikimediaSites = map[string]string{"commons": "Wikimedia Commons", "meta": "Meta-Wiki", "species": "Wikispecies"}
+
+var rxWikiPath = regexp.MustCompile(`^/wiki/(.+)$`)
+
+func init() {
+	hosts := make([]string, 0, len(wikiProjects)+2*len(wikimediaSites))
+	for _, p := range wikiProjects {
+		hosts = append(hosts, "."+p+".org")
+	}
+	for sub := range wikimediaSites {
+		hosts = append(hosts, sub+".wikimedia.org", sub+".m.wikimedia.org")
+	}
+	RegisterCrawler(&HostCrawler{Key: "wikipedia", Hosts: hosts, Path: rxWikiPath, Use: UseWikipedia, OnFragment: wikiSection})
+}
+
+// UseWikipedia reads an article of any language edition of Wikipedia, its
+// sister projects and Wikimedia Commons, Meta-Wiki and Wikispecies from the
+// REST page summary, which comes with a clean title, the lead section as
+// plain text and a thumbnail. Mobile (m.) links are read from the desktop
+// site and canonicalized to it, so both are the same bookmark. Links to a
+// section share the article's entry and get the section in their title, see
+// wikiSection.
+func UseWikipedia(u string) (*MetaData, error) {
+	pu, err := url.Parse(u)
+	if err != nil {
+		return nil, err
+	}
+	p := rxWikiPath.FindStringSubmatch(pu.Path)
+	if p == nil {
+		return UseDefault(u)
+	}
+
+	// en.m.wikipedia.org is en.wikipedia.org
+	labels := strings.Split(strings.ToLower(pu.Hostname()), ".")
+	labels = slices.DeleteFunc(labels, func(l string) bool { return l == "m" })
+	host := strings.Join(labels, ".")
+	project := labels[len(labels)-2]
+	siteName := strings.ToUpper(project[:1]) + project[1:]
+	if project == "wikimedia" {
+		siteName = wikimediaSites[labels[0]]
+	}
+
+	var s struct {
+		Title  string `json:"title"`
+		Titles struct {
+			Normalized string `json:"normalized"`
+		} `json:"titles"`
+		Description string `json:"description"`
+		Extract     string `json:"extract"`
+		Lang        string `json:"lang"`
+		Timestamp   string `json:"timestamp"`
+		Thumbnail   struct {
+			Source string `json:"source"`
+		} `json:"thumbnail"`
+		ContentURLs struct {
+			Desktop struct {
+				Page string `json:"page"`
+			} `json:"desktop"`
+		} `json:"content_urls"`
+	}
+	title := strings.ReplaceAll(p[1], " ", "_")
+	if err := getJSON("https://"+host+"/api/rest_v1/page/summary/"+url.PathEscape(title), &s); err != nil || s.Title == "" {
+		return UseDefault(u)
+	}
+
+	name := s.Titles.Normalized
+	if name == "" {
+		name = s.Title
+	}
+	lang := s.Lang
+	if lang == "" && len(labels) > 2 && project != "wikimedia" {
+		lang = labels[0]
+	}
+
+	m := &MetaData{
+		Title:       name,
+		Description: normalizeSpace(s.Extract),
+		Image:       s.Thumbnail.Source,
+		Favicon:     "https://" + host + "/favicon.ico",
+		URL:         u,
+		Date:        s.Timestamp,
+		SiteName:    siteName,
+		Lang:        lang,
+		Type:        "page",
+	}
+	if m.Description == "" {
+		m.Description = s.Description
+	}
+	if cu, err := lib.Canonicalize(s.ContentURLs.Desktop.Page); err == nil && s.ContentURLs.Desktop.Page != "" {
+		m.Canonical = cu
+	}
+	return m, nil
+}
+
+// wikiSection adds the section a link points at to the title, as in
+// "Article § Section", and keeps the fragment in the URL.
+func wikiSection(m *MetaData, fragment string) {
+	section := strings.TrimSpace(strings.ReplaceAll(fragment, "_", " "))
+	if section == "" {
+		return
+	}
+	m.Title += " § " + section
+	m.URL += "#" + (&url.URL{Fragment: fragment}).EscapedFragment()
+}
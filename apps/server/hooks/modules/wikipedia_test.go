@@ -0,0 +1,53 @@
+package modules
+
+import (
+	"net/url"
+	"testing"
+)
+
+func TestUseWikipedia(t *testing.T) {
+	useFixtures(t, fixtures{"en.wikipedia.org/api/rest_v1/page/summary/Go_(programming_language)": "wikipedia_summary.json"})
+
+	// mobile links are read from the desktop site
+	m, err := UseWikipedia("https://en.m.wikipedia.org/wiki/Go_(programming_language)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Title != "Go (programming language)" || m.Lang != "en" || m.SiteName != "Wikipedia" {
+		t.Errorf("got title %q, lang %q, site %q", m.Title, m.Lang, m.SiteName)
+	}
+	if m.Description != "Go is a high-level general purpose programming language that is statically typed and compiled. It is known for the simplicity of its syntax." {
+		t.Errorf("got description %q", m.Description)
+	}
+	if m.Image != "https://upload.wikimedia.org/wikipedia/commons/thumb/0/05/Go_Logo_Blue.svg/320px-Go_Logo_Blue.svg.png" {
+		t.Errorf("got image %q", m.Image)
+	}
+	if m.Canonical != "https://en.wikipedia.org/wiki/Go_(programming_language)" || m.Favicon != "https://en.wikipedia.org/favicon.ico" {
+		t.Errorf("got canonical %q, favicon %q", m.Canonical, m.Favicon)
+	}
+}
+
+func TestUseWikipediaCommons(t *testing.T) {
+	useFixtures(t, fixtures{"commons.wikimedia.org/api/rest_v1/page/summary/File:Cat_November_2010-1a.jpg": "commons_summary.json"})
+
+	m, err := UseWikipedia("https://commons.m.wikimedia.org/wiki/File:Cat_November_2010-1a.jpg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Title != "File:Cat November 2010-1a.jpg" || m.SiteName != "Wikimedia Commons" || m.Lang != "en" {
+		t.Errorf("got title %q, site %q, lang %q", m.Title, m.SiteName, m.Lang)
+	}
+}
+
+func TestWikiSection(t *testing.T) {
+	pu, _ := url.Parse("https://en.wikipedia.org/wiki/Go_(programming_language)")
+	m := &MetaData{Title: "Go (programming language)", URL: pu.String()}
+	FindCrawler(pu).(FragmentCrawler).Fragment(m, "Design_principles")
+
+	if m.Title != "Go (programming language) § Design principles" {
+		t.Errorf("got title %q", m.Title)
+	}
+	if m.URL != "https://en.wikipedia.org/wiki/Go_(programming_language)#Design_principles" {
+		t.Errorf("got url %q", m.URL)
+	}
+}
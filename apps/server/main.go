@@ -1,9 +1,12 @@
 package main
 
 import (
+	"context"
 	"embed"
 	"log"
+	"maps"
 	"os"
+	"slices"
 	"strings"
 	"time"
 
@@ -16,6 +19,7 @@ import (
 	"github.com/pocketbase/pocketbase/plugins/migratecmd"
 	"github.com/pocketbase/pocketbase/tools/types"
 
+	"dotpen.co/server/hooks/lib"
 	"dotpen.co/server/hooks/modules"
 )
 
@@ -40,13 +44,65 @@ func main() {
 			return nil
 		}).Bind(apis.RequireAuth())
 
+		se.Router.POST("/api/crawl/batch", func(e *core.RequestEvent) error {
+			modules.UseCrawlBatch(e.Response, e.Request, app)
+			return nil
+		}).Bind(apis.RequireAuth())
+
 		se.Router.GET("/api/proxy", func(e *core.RequestEvent) error {
 			modules.UseProxy(e.Response, e.Request)
 			return nil
 		}).Bind(apis.RequireAuth())
 
+		se.Router.GET("/api/bookmarks/duplicates", func(e *core.RequestEvent) error {
+			modules.UseDuplicates(e.Response, e.Request, app, e.Auth)
+			return nil
+		}).Bind(apis.RequireAuth())
+
+		se.Router.POST("/api/bookmarks/ingest", func(e *core.RequestEvent) error {
+			modules.UseIngest(e.Response, e.Request, app, e.Auth)
+			return nil
+		}).Bind(apis.RequireAuth())
+
+		se.Router.GET("/api/bookmarks/health", func(e *core.RequestEvent) error {
+			modules.UseLinkHealth(e.Response, e.Request, app, e.Auth)
+			return nil
+		}).Bind(apis.RequireAuth())
+
+		se.Router.POST("/api/bookmarks/health/rewrite", func(e *core.RequestEvent) error {
+			modules.UseRewriteRedirects(e.Response, e.Request, app, e.Auth)
+			return nil
+		}).Bind(apis.RequireAuth())
+
+		se.Router.GET("/api/bookmarks/{id}/archive", func(e *core.RequestEvent) error {
+			modules.UseArchive(e.Response, e.Request, app, e.Auth)
+			return nil
+		}).Bind(apis.RequireAuth())
+
+		se.Router.GET("/api/bookmarks/{id}/article", func(e *core.RequestEvent) error {
+			modules.UseArticle(e.Response, e.Request, app, e.Auth)
+			return nil
+		}).Bind(apis.RequireAuth())
+
+		se.Router.GET("/api/bookmarks/{id}/warc", func(e *core.RequestEvent) error {
+			modules.UseBookmarkWARC(e.Response, e.Request, app, e.Auth)
+			return nil
+		}).Bind(apis.RequireAuth())
+
+		se.Router.GET("/api/collections/{id}/warc", func(e *core.RequestEvent) error {
+			modules.UseCollectionWARC(e.Response, e.Request, app, e.Auth)
+			return nil
+		}).Bind(apis.RequireAuth())
+
 		se.Router.GET("/{path...}", apis.Static(os.DirFS("./public"), false))
 
+		ctx, cancel := context.WithCancel(context.Background())
+		app.OnTerminate().BindFunc(func(e *core.TerminateEvent) error {
+			cancel()
+			return e.Next()
+		})
+		modules.StartCrawlQueue(ctx, app)
+
 		jsvm.MustRegister(app, jsvm.Config{
 			HooksWatch:    true,
 			HooksPoolSize: 15,
@@ -55,6 +111,96 @@ func main() {
 		return se.Next()
 	})
 
+	app.OnRecordUpdate("bookmarks").BindFunc(func(e *core.RecordEvent) error {
+		modules.ResetLinkHealth(e.Record)
+		return e.Next()
+	})
+
+	// the link stays as entered, duplicates are found by its canonical form
+	canonicalLink := func(e *core.RecordEvent) error {
+		if link := e.Record.GetString("link"); link != "" {
+			if cu, err := lib.Canonicalize(link); err == nil {
+				// keep the canonical URL a crawled page declared, until the link changes
+				orig := e.Record.Original()
+				if e.Record.GetString("canonical") == orig.GetString("canonical") &&
+					(link != orig.GetString("link") || orig.GetString("canonical") == "") {
+					e.Record.Set("canonical", cu)
+				}
+			}
+		}
+		return e.Next()
+	}
+	app.OnRecordCreate("bookmarks").BindFunc(canonicalLink)
+	app.OnRecordUpdate("bookmarks").BindFunc(canonicalLink)
+
+	processCover := func(e *core.RecordEvent) error {
+		uploaded, err := modules.CheckCover(e.Record)
+		if err != nil {
+			return err
+		}
+		if err := e.Next(); err != nil {
+			return err
+		}
+		if uploaded {
+			if err := modules.EnqueueCover(e.App, e.Record); err != nil {
+				app.Logger().Warn("Cover: Enqueue", "id", e.Record.Id, "error", err.Error())
+			}
+		}
+		return nil
+	}
+	app.OnRecordCreate("bookmarks").BindFunc(processCover)
+	app.OnRecordUpdate("bookmarks").BindFunc(processCover)
+
+	app.OnRecordCreate("bookmarks").BindFunc(func(e *core.RecordEvent) error {
+		if err := modules.CheckDuplicate(app, e.Record); err != nil {
+			return err
+		}
+		return e.Next()
+	})
+
+	app.OnRecordAfterCreateSuccess("bookmarks").BindFunc(func(e *core.RecordEvent) error {
+		if modules.ArchiveEnabled() {
+			if err := modules.EnqueueArchive(app, e.Record); err != nil {
+				app.Logger().Warn("RecordCreate: bookmarks", "action", "archive", "error", err.Error())
+			}
+		}
+		return e.Next()
+	})
+
+	checkDuplicateOf := func(e *core.RecordRequestEvent) error {
+		if err := modules.CheckDuplicateOf(app, e.Record); err != nil {
+			return err
+		}
+		return e.Next()
+	}
+	app.OnRecordCreateRequest("bookmarks").BindFunc(checkDuplicateOf)
+	app.OnRecordUpdateRequest("bookmarks").BindFunc(checkDuplicateOf)
+
+	app.OnRecordCreateRequest("bookmarks").BindFunc(func(e *core.RecordRequestEvent) error {
+		if err := e.Next(); err != nil {
+			return err
+		}
+		// bookmarks made through the records API skip ingest, so they are
+		// filled in by the crawl queue, keeping what the client set
+		if err := modules.EnqueueRefresh(e.App, e.Record); err != nil {
+			app.Logger().Warn("RecordCreate: bookmarks", "action", "refresh", "error", err.Error())
+		}
+		return nil
+	})
+
+	app.OnRecordUpdateRequest("bookmarks").BindFunc(func(e *core.RecordRequestEvent) error {
+		info, err := e.RequestInfo()
+		if err != nil {
+			return err
+		}
+		submitted := slices.Collect(maps.Keys(info.Body))
+		if e.Request.MultipartForm != nil {
+			submitted = slices.AppendSeq(submitted, maps.Keys(e.Request.MultipartForm.File))
+		}
+		modules.MarkOverrides(e.Record, submitted)
+		return e.Next()
+	})
+
 	app.OnRecordUpdateExecute("bookmarks").BindFunc(func(e *core.RecordEvent) error {
 		app.Logger().Debug("RecordUpdate: bookmarks", "action", "update")
 
@@ -65,6 +211,11 @@ func main() {
 			e.Record.Set("link", "")
 			e.Record.Set("favicon", "")
 			e.Record.Set("cover", "")
+			e.Record.Set("content", "")
+			e.Record.Set("text", "")
+			e.Record.Set("words", 0)
+			e.Record.Set("archive", "")
+			e.Record.Set("warc", "")
 
 			app.Save(e.Record)
 		}
@@ -102,6 +253,36 @@ func main() {
 		}
 	})
 
+	app.Cron().MustAdd("Check bookmark links", "30 * * * *", func() {
+		app.Logger().Debug("Cron: Check bookmark links")
+		modules.CheckLinks(app)
+	})
+
+	app.Cron().MustAdd("Refresh stale bookmarks", "15 * * * *", func() {
+		app.Logger().Debug("Cron: Refresh stale bookmarks")
+		modules.RefreshStale(app)
+	})
+
+	app.Cron().MustAdd("Remove finished crawl jobs", "0 1 * * *", func() {
+		app.Logger().Debug("Cron: Remove finished crawl jobs")
+
+		cutoff := types.NowDateTime().Add(-7 * 24 * time.Hour)
+		records, err := app.FindAllRecords("crawl_jobs",
+			dbx.In("status", "done", "failed"),
+			dbx.NewExp("updated < {:cutoff}", dbx.Params{"cutoff": cutoff.String()}),
+		)
+		if err != nil {
+			app.Logger().Error("Cron: Remove finished crawl jobs", "error", err.Error())
+			return
+		}
+
+		for _, r := range records {
+			if err := app.Delete(r); err != nil {
+				app.Logger().Error("Cron: Deleting crawl job", "id", r.Id, "error", err.Error())
+			}
+		}
+	})
+
 	if err := app.Start(); err != nil {
 		log.Fatal(err)
 	}
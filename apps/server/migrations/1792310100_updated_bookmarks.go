@@ -0,0 +1,159 @@
+package migrations
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/pocketbase/pocketbase/core"
+	m "github.com/pocketbase/pocketbase/migrations"
+)
+
+func init() {
+	m.Register(func(app core.App) error {
+		collection, err := app.FindCollectionByNameOrId("pbc_1125843985")
+		if err != nil {
+			return err
+		}
+
+		// add field
+		if err := collection.Fields.AddMarshaledJSONAt(7, []byte(`{
+			"autogeneratePattern": "",
+			"hidden": false,
+			"id": "text2949836948",
+			"max": 0,
+			"min": 0,
+			"name": "canonical",
+			"pattern": "",
+			"presentable": false,
+			"primaryKey": false,
+			"required": false,
+			"system": false,
+			"type": "text"
+		}`)); err != nil {
+			return err
+		}
+
+		// add field
+		if err := collection.Fields.AddMarshaledJSONAt(8, []byte(`{
+			"cascadeDelete": false,
+			"collectionId": "pbc_1125843985",
+			"hidden": false,
+			"id": "relation71709216",
+			"maxSelect": 1,
+			"minSelect": 0,
+			"name": "duplicate_of",
+			"presentable": false,
+			"required": false,
+			"system": false,
+			"type": "relation"
+		}`)); err != nil {
+			return err
+		}
+
+		// add index
+		collection.AddIndex("idx_bookmarks_canonical", false, "`canonical`", "")
+
+		if err := app.Save(collection); err != nil {
+			return err
+		}
+
+		// backfill, so links saved before this migration are compared as well
+		records, err := app.FindAllRecords(collection)
+		if err != nil {
+			return err
+		}
+		for _, r := range records {
+			if cu, err := canonicalize1792310100(r.GetString("link")); err == nil {
+				r.Set("canonical", cu)
+				if err := app.SaveNoValidate(r); err != nil {
+					return err
+				}
+			}
+		}
+
+		return nil
+	}, func(app core.App) error {
+		collection, err := app.FindCollectionByNameOrId("pbc_1125843985")
+		if err != nil {
+			return err
+		}
+
+		// remove index
+		collection.RemoveIndex("idx_bookmarks_canonical")
+
+		// remove field
+		collection.Fields.RemoveById("text2949836948")
+
+		// remove field
+		collection.Fields.RemoveById("relation71709216")
+
+		return app.Save(collection)
+	})
+}
+
+// canonicalize1792310100 is the link canonicalization as of this migration,
+// kept here so the backfill doesn't change with the live code.
+func canonicalize1792310100(u string) (string, error) {
+	u = strings.TrimSpace(u)
+	if !strings.Contains(u, "://") {
+		u = "https://" + u
+	}
+
+	pu, err := url.Parse(u)
+	if err != nil {
+		return "", err
+	}
+	pu.Scheme = strings.ToLower(pu.Scheme)
+	if (pu.Scheme != "http" && pu.Scheme != "https") || pu.Hostname() == "" {
+		return "", fmt.Errorf("invalid url")
+	}
+
+	host := strings.TrimSuffix(strings.ToLower(pu.Hostname()), ".")
+	port := pu.Port()
+	if (pu.Scheme == "http" && port == "80") || (pu.Scheme == "https" && port == "443") {
+		port = ""
+	}
+	if strings.Contains(host, ":") {
+		host = "[" + host + "]"
+	}
+	if port != "" {
+		host += ":" + port
+	}
+	pu.Host = host
+
+	if pu.Path == "" {
+		pu.Path = "/"
+	}
+	pu.Fragment = ""
+	pu.RawFragment = ""
+	pu.User = nil
+
+	params := []string{
+		"utm_*", "fbclid", "gclid", "dclid", "gbraid", "wbraid", "msclkid", "yclid",
+		"twclid", "ttclid", "li_fat_id", "igshid", "si", "mc_cid", "mc_eid",
+		"_hsenc", "_hsmi", "mkt_tok", "oly_anon_id", "oly_enc_id", "vero_id",
+		"_ga", "_gl", "ref_src", "ref_url", "spm",
+	}
+	if extra := os.Getenv("TRACKING_PARAMS"); extra != "" {
+		params = append(params, strings.Split(extra, ",")...)
+	}
+
+	q := pu.Query()
+	for name := range q {
+		for _, p := range params {
+			p = strings.ToLower(strings.TrimSpace(p))
+			if p == "" {
+				continue
+			}
+			if n := strings.ToLower(name); n == p || (strings.HasSuffix(p, "*") && strings.HasPrefix(n, strings.TrimSuffix(p, "*"))) {
+				q.Del(name)
+				break
+			}
+		}
+	}
+	pu.RawQuery = q.Encode()
+
+	return pu.String(), nil
+}
@@ -0,0 +1,82 @@
+package migrations
+
+import (
+	"github.com/pocketbase/pocketbase/core"
+	m "github.com/pocketbase/pocketbase/migrations"
+)
+
+func init() {
+	m.Register(func(app core.App) error {
+		collection, err := app.FindCollectionByNameOrId("pbc_1125843985")
+		if err != nil {
+			return err
+		}
+
+		// add field
+		if err := collection.Fields.AddMarshaledJSONAt(9, []byte(`{
+			"convertURLs": false,
+			"hidden": false,
+			"id": "editor4274335913",
+			"maxSize": 0,
+			"name": "content",
+			"presentable": false,
+			"required": false,
+			"system": false,
+			"type": "editor"
+		}`)); err != nil {
+			return err
+		}
+
+		// add field
+		if err := collection.Fields.AddMarshaledJSONAt(10, []byte(`{
+			"autogeneratePattern": "",
+			"hidden": false,
+			"id": "text999008199",
+			"max": 1000000,
+			"min": 0,
+			"name": "text",
+			"pattern": "",
+			"presentable": false,
+			"primaryKey": false,
+			"required": false,
+			"system": false,
+			"type": "text"
+		}`)); err != nil {
+			return err
+		}
+
+		// add field
+		if err := collection.Fields.AddMarshaledJSONAt(11, []byte(`{
+			"hidden": false,
+			"id": "number1904025228",
+			"max": null,
+			"min": null,
+			"name": "words",
+			"onlyInt": true,
+			"presentable": false,
+			"required": false,
+			"system": false,
+			"type": "number"
+		}`)); err != nil {
+			return err
+		}
+
+		return app.Save(collection)
+	}, func(app core.App) error {
+		collection, err := app.FindCollectionByNameOrId("pbc_1125843985")
+		if err != nil {
+			return err
+		}
+
+		// remove field
+		collection.Fields.RemoveById("editor4274335913")
+
+		// remove field
+		collection.Fields.RemoveById("text999008199")
+
+		// remove field
+		collection.Fields.RemoveById("number1904025228")
+
+		return app.Save(collection)
+	})
+}
@@ -0,0 +1,47 @@
+package migrations
+
+import (
+	"github.com/pocketbase/pocketbase/core"
+	m "github.com/pocketbase/pocketbase/migrations"
+)
+
+func init() {
+	m.Register(func(app core.App) error {
+		collection, err := app.FindCollectionByNameOrId("pbc_1125843985")
+		if err != nil {
+			return err
+		}
+
+		// add field
+		if err := collection.Fields.AddMarshaledJSONAt(12, []byte(`{
+			"hidden": false,
+			"id": "file3590086044",
+			"maxSelect": 1,
+			"maxSize": 52428800,
+			"mimeTypes": [
+				"text/html"
+			],
+			"name": "archive",
+			"presentable": false,
+			"protected": true,
+			"required": false,
+			"system": false,
+			"thumbs": [],
+			"type": "file"
+		}`)); err != nil {
+			return err
+		}
+
+		return app.Save(collection)
+	}, func(app core.App) error {
+		collection, err := app.FindCollectionByNameOrId("pbc_1125843985")
+		if err != nil {
+			return err
+		}
+
+		// remove field
+		collection.Fields.RemoveById("file3590086044")
+
+		return app.Save(collection)
+	})
+}
@@ -0,0 +1,101 @@
+package migrations
+
+import (
+	"github.com/pocketbase/pocketbase/core"
+	m "github.com/pocketbase/pocketbase/migrations"
+)
+
+func init() {
+	m.Register(func(app core.App) error {
+		collection, err := app.FindCollectionByNameOrId("pbc_1125843985")
+		if err != nil {
+			return err
+		}
+
+		// add field
+		if err := collection.Fields.AddMarshaledJSONAt(14, []byte(`{
+			"hidden": false,
+			"id": "number2063623452",
+			"max": null,
+			"min": null,
+			"name": "status",
+			"onlyInt": true,
+			"presentable": false,
+			"required": false,
+			"system": false,
+			"type": "number"
+		}`)); err != nil {
+			return err
+		}
+
+		// add field
+		if err := collection.Fields.AddMarshaledJSONAt(15, []byte(`{
+			"exceptDomains": [],
+			"hidden": false,
+			"id": "url3272384043",
+			"name": "redirect",
+			"onlyDomains": [],
+			"presentable": false,
+			"required": false,
+			"system": false,
+			"type": "url"
+		}`)); err != nil {
+			return err
+		}
+
+		// add field
+		if err := collection.Fields.AddMarshaledJSONAt(16, []byte(`{
+			"hidden": false,
+			"id": "date2902702723",
+			"max": "",
+			"min": "",
+			"name": "checked",
+			"presentable": false,
+			"required": false,
+			"system": false,
+			"type": "date"
+		}`)); err != nil {
+			return err
+		}
+
+		// add field
+		if err := collection.Fields.AddMarshaledJSONAt(17, []byte(`{
+			"hidden": false,
+			"id": "bool3802901620",
+			"name": "broken",
+			"presentable": false,
+			"required": false,
+			"system": false,
+			"type": "bool"
+		}`)); err != nil {
+			return err
+		}
+
+		// add index
+		collection.AddIndex("idx_bookmarks_checked", false, "`checked`", "")
+
+		return app.Save(collection)
+	}, func(app core.App) error {
+		collection, err := app.FindCollectionByNameOrId("pbc_1125843985")
+		if err != nil {
+			return err
+		}
+
+		// remove field
+		collection.Fields.RemoveById("number2063623452")
+
+		// remove field
+		collection.Fields.RemoveById("url3272384043")
+
+		// remove field
+		collection.Fields.RemoveById("date2902702723")
+
+		// remove field
+		collection.Fields.RemoveById("bool3802901620")
+
+		// remove index
+		collection.RemoveIndex("idx_bookmarks_checked")
+
+		return app.Save(collection)
+	})
+}
@@ -0,0 +1,171 @@
+package migrations
+
+import (
+	"github.com/pocketbase/pocketbase/core"
+	m "github.com/pocketbase/pocketbase/migrations"
+)
+
+func init() {
+	m.Register(func(app core.App) error {
+		collection, err := app.FindCollectionByNameOrId("pbc_1125843985")
+		if err != nil {
+			return err
+		}
+
+		// add field
+		if err := collection.Fields.AddMarshaledJSONAt(22, []byte(`{
+			"autogeneratePattern": "",
+			"hidden": false,
+			"id": "text1706673151",
+			"max": 200,
+			"min": 0,
+			"name": "site_name",
+			"pattern": "",
+			"presentable": false,
+			"primaryKey": false,
+			"required": false,
+			"system": false,
+			"type": "text"
+		}`)); err != nil {
+			return err
+		}
+
+		// add field
+		if err := collection.Fields.AddMarshaledJSONAt(23, []byte(`{
+			"hidden": false,
+			"id": "select2363381545",
+			"maxSelect": 1,
+			"name": "type",
+			"presentable": false,
+			"required": false,
+			"system": false,
+			"type": "select",
+			"values": [
+				"page",
+				"pdf",
+				"image",
+				"audio",
+				"video",
+				"file"
+			]
+		}`)); err != nil {
+			return err
+		}
+
+		// add field
+		if err := collection.Fields.AddMarshaledJSONAt(24, []byte(`{
+			"autogeneratePattern": "",
+			"hidden": false,
+			"id": "text3803843775",
+			"max": 100,
+			"min": 0,
+			"name": "og_type",
+			"pattern": "",
+			"presentable": false,
+			"primaryKey": false,
+			"required": false,
+			"system": false,
+			"type": "text"
+		}`)); err != nil {
+			return err
+		}
+
+		// add field
+		if err := collection.Fields.AddMarshaledJSONAt(25, []byte(`{
+			"autogeneratePattern": "",
+			"hidden": false,
+			"id": "text822707298",
+			"max": 35,
+			"min": 0,
+			"name": "lang",
+			"pattern": "",
+			"presentable": false,
+			"primaryKey": false,
+			"required": false,
+			"system": false,
+			"type": "text"
+		}`)); err != nil {
+			return err
+		}
+
+		// add field
+		if err := collection.Fields.AddMarshaledJSONAt(26, []byte(`{
+			"hidden": false,
+			"id": "json1874629670",
+			"maxSize": 0,
+			"name": "tags",
+			"presentable": false,
+			"required": false,
+			"system": false,
+			"type": "json"
+		}`)); err != nil {
+			return err
+		}
+
+		// add field
+		if err := collection.Fields.AddMarshaledJSONAt(27, []byte(`{
+			"hidden": false,
+			"id": "json376077238",
+			"maxSize": 0,
+			"name": "twitter",
+			"presentable": false,
+			"required": false,
+			"system": false,
+			"type": "json"
+		}`)); err != nil {
+			return err
+		}
+
+		// add field
+		if err := collection.Fields.AddMarshaledJSONAt(28, []byte(`{
+			"hidden": false,
+			"id": "number1489170449",
+			"max": null,
+			"min": null,
+			"name": "reading_time",
+			"onlyInt": true,
+			"presentable": false,
+			"required": false,
+			"system": false,
+			"type": "number"
+		}`)); err != nil {
+			return err
+		}
+
+		// add index
+		collection.AddIndex("idx_bookmarks_site_name", false, "`site_name`", "")
+
+		return app.Save(collection)
+	}, func(app core.App) error {
+		collection, err := app.FindCollectionByNameOrId("pbc_1125843985")
+		if err != nil {
+			return err
+		}
+
+		// remove field
+		collection.Fields.RemoveById("text1706673151")
+
+		// remove field
+		collection.Fields.RemoveById("select2363381545")
+
+		// remove field
+		collection.Fields.RemoveById("text3803843775")
+
+		// remove field
+		collection.Fields.RemoveById("text822707298")
+
+		// remove field
+		collection.Fields.RemoveById("json1874629670")
+
+		// remove field
+		collection.Fields.RemoveById("json376077238")
+
+		// remove field
+		collection.Fields.RemoveById("number1489170449")
+
+		// remove index
+		collection.RemoveIndex("idx_bookmarks_site_name")
+
+		return app.Save(collection)
+	})
+}
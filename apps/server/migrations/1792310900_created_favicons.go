@@ -0,0 +1,145 @@
+package migrations
+
+import (
+	"encoding/json"
+
+	"github.com/pocketbase/pocketbase/core"
+	m "github.com/pocketbase/pocketbase/migrations"
+)
+
+func init() {
+	m.Register(func(app core.App) error {
+		jsonData := `{
+			"createRule": null,
+			"deleteRule": null,
+			"fields": [
+				{
+					"autogeneratePattern": "[a-z0-9]{15}",
+					"hidden": false,
+					"id": "text3208210256",
+					"max": 15,
+					"min": 15,
+					"name": "id",
+					"pattern": "^[a-z0-9]+$",
+					"presentable": false,
+					"primaryKey": true,
+					"required": true,
+					"system": true,
+					"type": "text"
+				},
+				{
+					"autogeneratePattern": "",
+					"hidden": false,
+					"id": "text3475444733",
+					"max": 255,
+					"min": 0,
+					"name": "host",
+					"pattern": "",
+					"presentable": false,
+					"primaryKey": false,
+					"required": true,
+					"system": false,
+					"type": "text"
+				},
+				{
+					"hidden": false,
+					"id": "file1704208859",
+					"maxSelect": 1,
+					"maxSize": 1048576,
+					"mimeTypes": [
+						"image/png",
+						"image/svg+xml",
+						"image/jpeg",
+						"image/gif",
+						"image/webp"
+					],
+					"name": "icon",
+					"presentable": false,
+					"protected": false,
+					"required": false,
+					"system": false,
+					"thumbs": [],
+					"type": "file"
+				},
+				{
+					"exceptDomains": [],
+					"hidden": false,
+					"id": "url1602912115",
+					"name": "source",
+					"onlyDomains": [],
+					"presentable": false,
+					"required": false,
+					"system": false,
+					"type": "url"
+				},
+				{
+					"hidden": false,
+					"id": "number2350531887",
+					"max": null,
+					"min": null,
+					"name": "width",
+					"onlyInt": true,
+					"presentable": false,
+					"required": false,
+					"system": false,
+					"type": "number"
+				},
+				{
+					"hidden": false,
+					"id": "date476284561",
+					"max": "",
+					"min": "",
+					"name": "fetched",
+					"presentable": false,
+					"required": false,
+					"system": false,
+					"type": "date"
+				},
+				{
+					"hidden": false,
+					"id": "autodate2990389176",
+					"name": "created",
+					"onCreate": true,
+					"onUpdate": false,
+					"presentable": false,
+					"system": false,
+					"type": "autodate"
+				},
+				{
+					"hidden": false,
+					"id": "autodate3332085495",
+					"name": "updated",
+					"onCreate": true,
+					"onUpdate": true,
+					"presentable": false,
+					"system": false,
+					"type": "autodate"
+				}
+			],
+			"id": "pbc_1330935995",
+			"indexes": [
+				"CREATE UNIQUE INDEX ` + "`" + `idx_favicons_host` + "`" + ` ON ` + "`" + `favicons` + "`" + ` (` + "`" + `host` + "`" + `)"
+			],
+			"listRule": null,
+			"name": "favicons",
+			"system": false,
+			"type": "base",
+			"updateRule": null,
+			"viewRule": null
+		}`
+
+		collection := &core.Collection{}
+		if err := json.Unmarshal([]byte(jsonData), &collection); err != nil {
+			return err
+		}
+
+		return app.Save(collection)
+	}, func(app core.App) error {
+		collection, err := app.FindCollectionByNameOrId("pbc_1330935995")
+		if err != nil {
+			return err
+		}
+
+		return app.Delete(collection)
+	})
+}
@@ -0,0 +1,127 @@
+package migrations
+
+import (
+	"github.com/pocketbase/pocketbase/core"
+	m "github.com/pocketbase/pocketbase/migrations"
+)
+
+func init() {
+	m.Register(func(app core.App) error {
+		collection, err := app.FindCollectionByNameOrId("pbc_1125843985")
+		if err != nil {
+			return err
+		}
+
+		// add field
+		if err := collection.Fields.AddMarshaledJSONAt(29, []byte(`{
+			"hidden": false,
+			"id": "file1805489594",
+			"maxSelect": 5,
+			"maxSize": 5242880,
+			"mimeTypes": [
+				"image/webp"
+			],
+			"name": "cover_variants",
+			"presentable": false,
+			"protected": false,
+			"required": false,
+			"system": false,
+			"thumbs": [],
+			"type": "file"
+		}`)); err != nil {
+			return err
+		}
+
+		// add field
+		if err := collection.Fields.AddMarshaledJSONAt(30, []byte(`{
+			"autogeneratePattern": "",
+			"hidden": false,
+			"id": "text1274939932",
+			"max": 100,
+			"min": 0,
+			"name": "cover_blurhash",
+			"pattern": "",
+			"presentable": false,
+			"primaryKey": false,
+			"required": false,
+			"system": false,
+			"type": "text"
+		}`)); err != nil {
+			return err
+		}
+
+		// add field
+		if err := collection.Fields.AddMarshaledJSONAt(31, []byte(`{
+			"autogeneratePattern": "",
+			"hidden": false,
+			"id": "text3216297596",
+			"max": 7,
+			"min": 0,
+			"name": "cover_color",
+			"pattern": "",
+			"presentable": false,
+			"primaryKey": false,
+			"required": false,
+			"system": false,
+			"type": "text"
+		}`)); err != nil {
+			return err
+		}
+
+		// add field
+		if err := collection.Fields.AddMarshaledJSONAt(32, []byte(`{
+			"hidden": false,
+			"id": "number1442366394",
+			"max": null,
+			"min": null,
+			"name": "cover_width",
+			"onlyInt": true,
+			"presentable": false,
+			"required": false,
+			"system": false,
+			"type": "number"
+		}`)); err != nil {
+			return err
+		}
+
+		// add field
+		if err := collection.Fields.AddMarshaledJSONAt(33, []byte(`{
+			"hidden": false,
+			"id": "number1978753090",
+			"max": null,
+			"min": null,
+			"name": "cover_height",
+			"onlyInt": true,
+			"presentable": false,
+			"required": false,
+			"system": false,
+			"type": "number"
+		}`)); err != nil {
+			return err
+		}
+
+		return app.Save(collection)
+	}, func(app core.App) error {
+		collection, err := app.FindCollectionByNameOrId("pbc_1125843985")
+		if err != nil {
+			return err
+		}
+
+		// remove field
+		collection.Fields.RemoveById("file1805489594")
+
+		// remove field
+		collection.Fields.RemoveById("text1274939932")
+
+		// remove field
+		collection.Fields.RemoveById("text3216297596")
+
+		// remove field
+		collection.Fields.RemoveById("number1442366394")
+
+		// remove field
+		collection.Fields.RemoveById("number1978753090")
+
+		return app.Save(collection)
+	})
+}
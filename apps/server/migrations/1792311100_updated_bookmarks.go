@@ -0,0 +1,48 @@
+package migrations
+
+import (
+	"github.com/pocketbase/pocketbase/core"
+	m "github.com/pocketbase/pocketbase/migrations"
+)
+
+func init() {
+	m.Register(func(app core.App) error {
+		collection, err := app.FindCollectionByNameOrId("pbc_1125843985")
+		if err != nil {
+			return err
+		}
+
+		// add field
+		if err := collection.Fields.AddMarshaledJSONAt(34, []byte(`{
+			"exceptDomains": [],
+			"hidden": false,
+			"id": "url3024863604",
+			"name": "discusses",
+			"onlyDomains": [],
+			"presentable": false,
+			"required": false,
+			"system": false,
+			"type": "url"
+		}`)); err != nil {
+			return err
+		}
+
+		// add index
+		collection.AddIndex("idx_bookmarks_discusses", false, "`discusses`", "")
+
+		return app.Save(collection)
+	}, func(app core.App) error {
+		collection, err := app.FindCollectionByNameOrId("pbc_1125843985")
+		if err != nil {
+			return err
+		}
+
+		// remove field
+		collection.Fields.RemoveById("url3024863604")
+
+		// remove index
+		collection.RemoveIndex("idx_bookmarks_discusses")
+
+		return app.Save(collection)
+	})
+}